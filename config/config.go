@@ -22,6 +22,13 @@ type Config struct {
 	HMACKey   string         `yaml:"hmac_key"`
 	CSRFBytes int            `yaml:"csrf_bytes"`
 	Database  PostgresConfig `yaml:"database"`
+	Site      SiteConfig     `yaml:"site"`
+	// SyncOnStart will sync the posts table with the markdown on disk before the server starts listening.
+	SyncOnStart bool         `yaml:"sync_on_start"`
+	Feeds       FeedsConfig  `yaml:"feeds"`
+	Robots      RobotsConfig `yaml:"robots"`
+	Mail        MailConfig   `yaml:"mail"`
+	Auth        AuthConfig   `yaml:"auth"`
 }
 
 // LoadConfig will load production or development configuration files.
@@ -43,6 +50,9 @@ func LoadConfig() Config {
 	if err := d.Decode(&c); err != nil {
     panic(err)
   }
+	c.Site.setDefaults()
+	c.Robots.setDefaults()
+	c.Mail.setDefaults(c.Site)
 	return c
 }
 
@@ -51,6 +61,95 @@ func (c Config) IsProd() bool {
 	return c.Env == "prod"
 }
 
+// SiteConfig holds the identity of the site, used for feeds, page titles and absolute URLs.
+type SiteConfig struct {
+	Title       string `yaml:"title"`
+	BaseURL     string `yaml:"base_url"`
+	Author      string `yaml:"author"`
+	Email       string `yaml:"email"`
+	Description string `yaml:"description"`
+}
+
+// setDefaults fills in anything left out of the site section with the values of nathanielwheeler.com.
+func (c *SiteConfig) setDefaults() {
+	if c.Title == "" {
+		c.Title = "Nathan's Blog"
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = "https://nathanielwheeler.com"
+	}
+	if c.Author == "" {
+		c.Author = "Nathaniel Wheeler"
+	}
+	if c.Email == "" {
+		c.Email = "nathan@mailftp.com"
+	}
+	if c.Description == "" {
+		c.Description = "A blog about code and whatever I feel like."
+	}
+}
+
+// FeedsConfig holds feed settings.
+type FeedsConfig struct {
+	// ExportDir is where feeds are written as static files whenever they are rebuilt.  Feeds are only served from memory when it is empty.
+	ExportDir string `yaml:"export_dir"`
+	// HubURL is the WebSub hub that feeds advertise and that is pinged whenever posts change.
+	HubURL string `yaml:"hub_url"`
+	// HubSecret signs pings to the hub, which has to be given the same secret to check them.  It must not be the HMAC key, since whoever runs the hub would then be able to forge tokens.  Pings are not signed when it is empty.
+	HubSecret string `yaml:"hub_secret"`
+	// Summary makes feeds hold only the excerpt of each post by default.  Readers can still ask for either with ?mode=full or ?mode=summary.
+	Summary bool `yaml:"summary"`
+}
+
+// RobotsConfig holds the rules of robots.txt.
+type RobotsConfig struct {
+	// Disallow lists the path prefixes crawlers should stay out of.  A staging site can disallow "/" to keep itself out of search results.
+	Disallow []string `yaml:"disallow"`
+}
+
+// setDefaults keeps crawlers out of the admin and account pages unless the config says otherwise.
+func (c *RobotsConfig) setDefaults() {
+	if c.Disallow == nil {
+		c.Disallow = []string{"/posts/", "/login", "/register", "/forgot", "/reset", "/verify", "/account"}
+	}
+}
+
+// AuthConfig holds settings for signing in.
+type AuthConfig struct {
+	// AdminTwoFactor makes every user whose role can change posts or images turn on two-factor authentication before they can use any admin page.
+	AdminTwoFactor bool `yaml:"admin_two_factor"`
+	// MemoryLoginLimits keeps failed logins in memory instead of the database.  They are then forgotten on restart.
+	MemoryLoginLimits bool `yaml:"memory_login_limits"`
+}
+
+// MailConfig holds the settings of outgoing mail.  Mail is sent through SMTP when a host is set, otherwise it is written to OutboxDir.
+type MailConfig struct {
+	From      string     `yaml:"from"`
+	OutboxDir string     `yaml:"outbox_dir"`
+	SMTP      SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig holds the connection info of an SMTP server.  User and Password can be left out for servers that don't need to authenticate.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// setDefaults sends mail from the email of the site, through port 587, or into the outbox directory when there is no SMTP server.
+func (c *MailConfig) setDefaults(site SiteConfig) {
+	if c.From == "" {
+		c.From = site.Email
+	}
+	if c.OutboxDir == "" {
+		c.OutboxDir = "outbox"
+	}
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = 587
+	}
+}
+
 // PostgresConfig holds database connection info.
 type PostgresConfig struct {
 	DBName   string `yaml:"name"`
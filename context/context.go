@@ -9,7 +9,10 @@ import (
 // NOTE these should never be exported.  This prevents outside code from changing these values.
 type privateKey string
 
-const userKey privateKey = "user"
+const (
+  userKey    privateKey = "user"
+  sessionKey privateKey = "session"
+)
 
 // WithUser accepts an existing context and a user, then returns a new context with that user set as a value.
 func WithUser(ctx context.Context, user *models.User) context.Context {
@@ -25,3 +28,18 @@ func User(ctx context.Context) *models.User {
   }
   return nil
 }
+
+// WithSession accepts an existing context and the session a request was signed in with, then returns a new context with that session set as a value.
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+  return context.WithValue(ctx, sessionKey, session)
+}
+
+// Session will look up the session of the signed in user from a given context.
+func Session(ctx context.Context) *models.Session {
+  if temp := ctx.Value(sessionKey); temp != nil {
+    if session, ok := temp.(*models.Session); ok {
+      return session
+    }
+  }
+  return nil
+}
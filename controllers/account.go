@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nathanielwheeler.com/context"
+	"nathanielwheeler.com/models"
+	"nathanielwheeler.com/views"
+
+	"github.com/gorilla/mux"
+	"rsc.io/qr"
+)
+
+// Account holds the views and services of the account pages, where users manage how they are signed in.
+type Account struct {
+	AccountView   *views.View
+	TwoFactorView *views.View
+	us            models.UserService
+	ss            models.SessionService
+	site          models.Site
+	// adminTwoFactor keeps users whose role can change posts or images from turning two-factor authentication off.
+	adminTwoFactor bool
+}
+
+// NewAccount initializes the views for the account pages.  The title of the site names it in authenticator apps.
+func NewAccount(us models.UserService, ss models.SessionService, site models.Site, adminTwoFactor bool) *Account {
+	return &Account{
+		AccountView:    views.NewView("app", "users/account"),
+		TwoFactorView:  views.NewView("app", "users/setup2fa"),
+		us:             us,
+		ss:             ss,
+		site:           site,
+		adminTwoFactor: adminTwoFactor,
+	}
+}
+
+// AccountPage is the data of the account page.
+type AccountPage struct {
+	User     *models.User
+	Sessions []models.Session
+	// CurrentID is the session of the device looking at the page.
+	CurrentID uint
+}
+
+// Show : GET /account
+// — Lists the devices that the user is signed in on
+func (a *Account) Show(res http.ResponseWriter, req *http.Request) {
+	a.render(res, req, views.Data{})
+}
+
+// RevokeSession : POST /account/sessions/:id/revoke
+// — Signs the user out of one device.  Revoking the current session is the same as logging out.
+func (a *Account) RevokeSession(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	user := context.User(req.Context())
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		http.Error(res, "Invalid session ID", http.StatusNotFound)
+		return
+	}
+	if err := a.ss.Delete(user.ID, uint(id)); err != nil {
+		vd.SetAlert(err)
+		a.render(res, req, vd)
+		return
+	}
+	if current := context.Session(req.Context()); current != nil && current.ID == uint(id) {
+		http.Redirect(res, req, "/", http.StatusFound)
+		return
+	}
+	vd.RedirectAlert(res, req, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "That device has been signed out.",
+	})
+}
+
+// RevokeAll : POST /account/sessions/revoke
+// — Signs the user out of every device, including this one
+func (a *Account) RevokeAll(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	user := context.User(req.Context())
+	if err := a.ss.DeleteByUser(user.ID); err != nil {
+		vd.SetAlert(err)
+		a.render(res, req, vd)
+		return
+	}
+	vd.RedirectAlert(res, req, "/login", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Every device has been signed out.",
+	})
+}
+
+// TwoFactorPage is the data of the two-factor authentication page.
+type TwoFactorPage struct {
+	User *models.User
+	// Required is set when the role of the user can change posts or images, so they have to keep two-factor authentication on.
+	Required bool
+	// Secret and QRCode are used to add a new secret to an authenticator app.  They are only set while two-factor authentication is off.  QRCode is a PNG data URI, drawn here so that no outside script ever sees the secret.
+	Secret string
+	QRCode template.URL
+	// RecoveryCodes are only set right after two-factor authentication is turned on, since they can't be shown again.
+	RecoveryCodes []string
+}
+
+// TwoFactor : GET /account/2fa
+// — Shows a QR code for a new secret, or lets the user turn two-factor authentication off
+func (a *Account) TwoFactor(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := a.twoFactorPage(req)
+	if !page.User.TwoFactor() {
+		secret, err := models.NewTOTPSecret()
+		if err != nil {
+			vd.SetAlert(err)
+		}
+		a.setSecret(&page, secret)
+		if page.Required {
+			vd.Alert = &views.Alert{
+				Level:   views.AlertLvlWarning,
+				Message: "Accounts that can change posts need two-factor authentication.  Please turn it on to continue.",
+			}
+		}
+	}
+	vd.Yield = page
+	a.TwoFactorView.Render(res, req, vd)
+}
+
+// EnableTwoFactor : POST /account/2fa/enable
+// — Turns two-factor authentication on once the user enters a code made from the new secret, then shows their recovery codes
+func (a *Account) EnableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := a.twoFactorPage(req)
+	var form TwoFactorForm
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = page
+		a.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	codes, err := a.us.EnableTOTP(page.User, form.Secret, form.Code)
+	if err != nil {
+		// The secret is kept, so that the code already in their app still works.
+		a.setSecret(&page, form.Secret)
+		vd.SetAlert(err)
+		vd.Yield = page
+		a.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	page.RecoveryCodes = codes
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is on.",
+	}
+	vd.Yield = page
+	a.TwoFactorView.Render(res, req, vd)
+}
+
+// DisableTwoFactor : POST /account/2fa/disable
+// — Turns two-factor authentication off, given a code
+func (a *Account) DisableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := a.twoFactorPage(req)
+	vd.Yield = page
+	if page.Required {
+		vd.AlertError("Accounts that can change posts need to keep two-factor authentication on.")
+		a.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	var form TwoFactorForm
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		a.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	if err := a.us.DisableTOTP(page.User, form.Code); err != nil {
+		vd.SetAlert(err)
+		a.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	vd.RedirectAlert(res, req, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is off.",
+	})
+}
+
+// twoFactorPage starts the two-factor authentication page of the user.
+func (a *Account) twoFactorPage(req *http.Request) TwoFactorPage {
+	user := context.User(req.Context())
+	return TwoFactorPage{
+		User:     user,
+		Required: a.adminTwoFactor && user.Role.Privileged(),
+	}
+}
+
+// setSecret adds a secret and a QR code of its URI to the page.  The page still shows the secret itself if the QR code can't be made.
+func (a *Account) setSecret(page *TwoFactorPage, secret string) {
+	if secret == "" {
+		return
+	}
+	page.Secret = secret
+	code, err := qr.Encode(models.TOTPURI(a.site.Title, page.User.Email, secret), qr.M)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	code.Scale = 4
+	page.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+}
+
+// render looks up the sessions of the user and renders the account page with them.
+func (a *Account) render(res http.ResponseWriter, req *http.Request, vd views.Data) {
+	user := context.User(req.Context())
+	page := AccountPage{User: user}
+	if current := context.Session(req.Context()); current != nil {
+		page.CurrentID = current.ID
+	}
+	sessions, err := a.ss.ByUser(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	page.Sessions = sessions
+	vd.Yield = page
+	a.AccountView.Render(res, req, vd)
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nathanielwheeler.com/models"
+	"nathanielwheeler.com/views"
+
+	"github.com/gorilla/mux"
+)
+
+// feedCacheControl lets feed readers and proxies reuse a feed for a few minutes before checking it again.
+const feedCacheControl = "public, max-age=300"
+
+// feedTypes maps each feed format to its media type.  Formats are listed in the order they are advertised.
+var feedTypes = []struct {
+	Format models.FeedFormat
+	Type   string
+}{
+	{models.FeedAtom, "application/atom+xml"},
+	{models.FeedRSS, "application/rss+xml"},
+	{models.FeedJSON, "application/feed+json"},
+}
+
+// Feeds serves the site feeds out of memory.
+type Feeds struct {
+	fs models.FeedsService
+}
+
+// NewFeeds is a constructor for Feeds struct
+func NewFeeds(fs models.FeedsService) *Feeds {
+	return &Feeds{
+		fs: fs,
+	}
+}
+
+// Site : GET /feeds/feed.:format?mode=
+func (f *Feeds) Site(res http.ResponseWriter, req *http.Request) {
+	f.serve(res, req, models.FeedFilter{})
+}
+
+// Tag : GET /feeds/tags/:tag.:format?mode=
+func (f *Feeds) Tag(res http.ResponseWriter, req *http.Request) {
+	f.serve(res, req, models.FeedFilter{Tag: mux.Vars(req)["tag"]})
+}
+
+// Series : GET /feeds/series/:slug.:format?mode=
+func (f *Feeds) Series(res http.ResponseWriter, req *http.Request) {
+	f.serve(res, req, models.FeedFilter{Series: mux.Vars(req)["slug"]})
+}
+
+// Year : GET /feeds/:year.:format?mode=
+func (f *Feeds) Year(res http.ResponseWriter, req *http.Request) {
+	year, err := strconv.Atoi(mux.Vars(req)["year"])
+	if err != nil {
+		http.Error(res, "Feed not found", http.StatusNotFound)
+		return
+	}
+	f.serve(res, req, models.FeedFilter{Year: year})
+}
+
+func (f *Feeds) serve(res http.ResponseWriter, req *http.Request, filter models.FeedFilter) {
+	format := models.FeedFormat(mux.Vars(req)["format"])
+	contentType := feedType(format)
+	if contentType == "" {
+		http.Error(res, "Feed not found", http.StatusNotFound)
+		return
+	}
+	// ?mode= picks between whole posts and excerpts.  Feeds use the configured mode without it.
+	mode := models.FeedMode(req.URL.Query().Get("mode"))
+	switch mode {
+	case "", models.FeedFull, models.FeedSummary:
+	default:
+		http.Error(res, "Unknown feed mode", http.StatusBadRequest)
+		return
+	}
+	snap, err := f.fs.Snapshot(filter, mode)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(res, "Feed not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		}
+		return
+	}
+	res.Header().Set("Cache-Control", feedCacheControl)
+	// WebSub subscribers may only look at the headers to discover the hub.
+	if hub := f.fs.Hub(); hub != "" {
+		res.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, hub))
+		res.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, f.fs.Topic(filter, format)))
+	}
+	if views.NotModified(res, req, snap.Updated, views.ETag(format, snap.Hash)) {
+		return
+	}
+	res.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	res.Write(snap.Format(format))
+}
+
+// feedType returns the media type of a feed format, or an empty string for unknown formats.
+func feedType(format models.FeedFormat) string {
+	for _, ft := range feedTypes {
+		if ft.Format == format {
+			return ft.Type
+		}
+	}
+	return ""
+}
+
+// feedLinks advertises a feed in every format.  title is left empty for the site-wide feed.
+func feedLinks(title string, filter models.FeedFilter) []views.FeedLink {
+	links := make([]views.FeedLink, 0, len(feedTypes))
+	for _, ft := range feedTypes {
+		links = append(links, views.FeedLink{
+			Title:  title,
+			Format: string(ft.Format),
+			Type:   ft.Type,
+			Href:   filter.Path() + "." + string(ft.Format),
+		})
+	}
+	return links
+}
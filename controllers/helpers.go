@@ -1,7 +1,10 @@
 package controllers
 
 import (
+  "net"
   "net/http"
+  "strconv"
+  "strings"
 
   "github.com/gorilla/schema"
 )
@@ -19,3 +22,30 @@ func parseForm(req *http.Request, dest interface{}) error {
 
   return nil
 }
+
+// pageParam reads the ?page= query parameter, defaulting to the first page.
+func pageParam(req *http.Request) int {
+  page, err := strconv.Atoi(req.URL.Query().Get("page"))
+  if err != nil || page < 1 {
+    return 1
+  }
+  return page
+}
+
+// clientIP returns the IP address of whoever made the request.  Behind the reverse proxy, every request comes from loopback, so the last address the proxy added to X-Forwarded-For is used instead.  Addresses further left are sent by the client and can't be trusted.
+func clientIP(req *http.Request) string {
+  host, _, err := net.SplitHostPort(req.RemoteAddr)
+  if err != nil {
+    host = req.RemoteAddr
+  }
+  ip := net.ParseIP(host)
+  if ip == nil || !ip.IsLoopback() {
+    return host
+  }
+  fwd := req.Header.Get("X-Forwarded-For")
+  if fwd == "" {
+    return host
+  }
+  hops := strings.Split(fwd, ",")
+  return strings.TrimSpace(hops[len(hops)-1])
+}
@@ -5,8 +5,10 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"nathanielwheeler.com/context"
+	"nathanielwheeler.com/diff"
 	"nathanielwheeler.com/models"
 	"nathanielwheeler.com/views"
 
@@ -15,46 +17,68 @@ import (
 
 // Named routes.
 const (
-	BlogIndexRoute = "blog_index"
-	BlogPostRoute  = "blog_post"
-	EditPost       = "edit_post"
+	BlogIndexRoute  = "blog_index"
+	BlogPostRoute   = "blog_post"
+	BlogTagsRoute   = "blog_tags"
+	BlogTagRoute    = "blog_tag"
+	BlogSeriesRoute = "blog_series"
+	EditPost        = "edit_post"
+	PostHistory     = "post_history"
 )
 
 const (
 	maxMultipartMem = 1 << 20 // 1 megabyte
+
+	homePerPage  = 5
+	indexPerPage = 25
 )
 
 // Posts will hold information about views and services
 type Posts struct {
-	HomeView      *views.View
-	BlogPostView  *views.View
-	BlogIndexView *views.View
-	New           *views.View
-	EditView      *views.View
-	FeedView      *views.View
-	ps            models.PostsService
-	is            models.ImagesService
-	r             *mux.Router
+	HomeView       *views.View
+	BlogPostView   *views.View
+	BlogIndexView  *views.View
+	BlogTagsView   *views.View
+	BlogTagView    *views.View
+	BlogSeriesView *views.View
+	New            *views.View
+	EditView       *views.View
+	SyncView       *views.View
+	HistoryView    *views.View
+	FeedView       *views.View
+	ps             models.PostsService
+	is             models.ImagesService
+	r              *mux.Router
 }
 
 // NewPosts is a constructor for Posts struct
 func NewPosts(ps models.PostsService, is models.ImagesService, r *mux.Router) *Posts {
 	return &Posts{
-		HomeView:      views.NewView("app", "posts/home", "posts/blog/card"),
-		BlogPostView:  views.NewView("app", "posts/blog/post", "posts/blog/card"),
-		BlogIndexView: views.NewView("app", "posts/blog/index"),
-		New:           views.NewView("app", "posts/new"),
-		EditView:      views.NewView("app", "posts/edit"),
-		ps:            ps,
-		is:            is,
-		r:             r,
+		HomeView:       views.NewView("app", "posts/home", "posts/blog/card"),
+		BlogPostView:   views.NewView("app", "posts/blog/post", "posts/blog/card"),
+		BlogIndexView:  views.NewView("app", "posts/blog/index"),
+		BlogTagsView:   views.NewView("app", "posts/blog/tags"),
+		BlogTagView:    views.NewView("app", "posts/blog/tag"),
+		BlogSeriesView: views.NewView("app", "posts/blog/series"),
+		New:            views.NewView("app", "posts/new"),
+		EditView:       views.NewView("app", "posts/edit"),
+		SyncView:       views.NewView("app", "posts/sync"),
+		HistoryView:    views.NewView("app", "posts/history"),
+		ps:             ps,
+		is:             is,
+		r:              r,
 	}
 }
 
 // Home : GET /
 // Needs to render the latest post
 func (p *Posts) Home(res http.ResponseWriter, req *http.Request) {
-	posts, err := p.ps.GetAll()
+	pagination, ok := p.paginate(res, req, homePerPage)
+	if !ok {
+		// paginate already renders error
+		return
+	}
+	posts, err := p.ps.GetPage(pagination.PerPage, pagination.Offset())
 	if err != nil {
 		log.Println(err)
 		return
@@ -69,10 +93,27 @@ func (p *Posts) Home(res http.ResponseWriter, req *http.Request) {
 	}
 
 	var vd views.Data
+	vd.Feeds = feedLinks("", models.FeedFilter{})
+	vd.LastModified = p.lastModified(posts...)
 	vd.Yield = posts
+	vd.Pagination = pagination
 	p.HomeView.Render(res, req, vd)
 }
 
+// PostPage holds a post along with the posts readers can go to from it.  Posts in a series link to their neighbours in the series, other posts link to their neighbours in time.
+type PostPage struct {
+	*models.Post
+	Series      []models.Post
+	SeriesIndex int
+	Prev        *models.Post
+	Next        *models.Post
+}
+
+// SeriesPart is the 1-based position of the post in its series.
+func (pp PostPage) SeriesPart() int {
+	return pp.SeriesIndex + 1
+}
+
 // BlogPost : GET /blog/:filepath
 func (p *Posts) BlogPost(res http.ResponseWriter, req *http.Request) {
 	post, err := p.postByURL(res, req)
@@ -84,36 +125,149 @@ func (p *Posts) BlogPost(res http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println(err)
 	}
+	page, err := p.postPage(post)
+	if err != nil {
+		log.Println(err)
+	}
 	var vd views.Data
-	vd.Yield = post
+	vd.Yield = page
+	vd.Description = post.Excerpt
+	vd.LastModified = p.lastModified(*post)
 	p.BlogPostView.Render(res, req, vd)
 }
 
+// SeriesPage holds the posts of a series, in order.
+type SeriesPage struct {
+	Name  string
+	Slug  string
+	Posts []models.Post
+}
+
+// BlogSeries : GET /blog/series/:slug
+func (p *Posts) BlogSeries(res http.ResponseWriter, req *http.Request) {
+	slug := mux.Vars(req)["slug"]
+	posts, err := p.ps.BySeries(slug)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) == 0 {
+		http.Error(res, "Series not found", http.StatusNotFound)
+		return
+	}
+	for i, post := range posts {
+		if err := p.ps.ParseMD(&post); err != nil {
+			log.Println(err)
+		}
+		posts[i] = post
+	}
+	var vd views.Data
+	vd.Feeds = feedLinks(posts[0].Series, models.FeedFilter{Series: slug})
+	vd.LastModified = p.lastModified(posts...)
+	vd.Yield = SeriesPage{
+		Name:  posts[0].Series,
+		Slug:  slug,
+		Posts: posts,
+	}
+	p.BlogSeriesView.Render(res, req, vd)
+}
+
 // BlogIndex : GET /blog
 func (p *Posts) BlogIndex(res http.ResponseWriter, req *http.Request) {
-	posts, err := p.ps.GetAll()
+	pagination, ok := p.paginate(res, req, indexPerPage)
+	if !ok {
+		// paginate already renders error
+		return
+	}
+	posts, err := p.ps.GetPage(pagination.PerPage, pagination.Offset())
 	if err != nil {
 		log.Println(err)
 		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
 		return
 	}
 	var vd views.Data
+	vd.Feeds = feedLinks("", models.FeedFilter{})
+	vd.LastModified = p.lastModified(posts...)
 	vd.Yield = posts
+	vd.Pagination = pagination
+	p.BlogIndexView.Render(res, req, vd)
+}
 
-	err = p.ps.MakePostsFeed()
+// BlogTags : GET /blog/tags
+func (p *Posts) BlogTags(res http.ResponseWriter, req *http.Request) {
+	tags, err := p.ps.Tags()
 	if err != nil {
-		vd.SetAlert(err)
-		vd.RedirectAlert(res, req, "/home", http.StatusFound, *vd.Alert)
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
 		return
 	}
-	p.BlogIndexView.Render(res, req, vd)
+	var vd views.Data
+	vd.LastModified = p.lastModified()
+	vd.Yield = tags
+	p.BlogTagsView.Render(res, req, vd)
+}
+
+// TagPage holds a tag and the posts that use it.
+type TagPage struct {
+	Tag   *models.Tag
+	Posts []models.Post
+}
+
+// BlogTag : GET /blog/tags/:tag
+func (p *Posts) BlogTag(res http.ResponseWriter, req *http.Request) {
+	slug := mux.Vars(req)["tag"]
+	tag, err := p.ps.TagBySlug(slug)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(res, "Tag not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		}
+		return
+	}
+	posts, err := p.ps.ByTag(tag.Slug)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Feeds = feedLinks(tag.Name, models.FeedFilter{Tag: tag.Slug})
+	vd.LastModified = p.lastModified(posts...)
+	vd.Yield = TagPage{
+		Tag:   tag,
+		Posts: posts,
+	}
+	p.BlogTagView.Render(res, req, vd)
 }
 
 // PostForm will hold information for creating a new post
 type PostForm struct {
-	Title    string `schema:"title"`
-	URLPath  string `schema:"urlpath"`
-	FilePath string `schema:"filepath"`
+	Title       string `schema:"title"`
+	URLPath     string `schema:"urlpath"`
+	FilePath    string `schema:"filepath"`
+	Draft       bool   `schema:"draft"`
+	PublishAt   string `schema:"publish_at"`
+	Series      string `schema:"series"`
+	SeriesOrder int    `schema:"series_order"`
+}
+
+// publishAtLayout is the format sent by datetime-local inputs.
+const publishAtLayout = "2006-01-02T15:04"
+
+// publishAt parses the PublishAt field of the form.  An empty field means the post isn't scheduled.
+func (f *PostForm) publishAt() (*time.Time, error) {
+	if f.PublishAt == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(publishAtLayout, f.PublishAt, time.Local)
+	if err != nil {
+		return nil, models.ErrPublishAtInvalid
+	}
+	return &t, nil
 }
 
 // Create : POST /posts
@@ -125,16 +279,21 @@ func (p *Posts) Create(res http.ResponseWriter, req *http.Request) {
 		p.New.Render(res, req, vd)
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to create a post", http.StatusForbidden)
+	publishAt, err := form.publishAt()
+	if err != nil {
+		vd.SetAlert(err)
+		p.New.Render(res, req, vd)
 		return
 	}
 	post := models.Post{
-		Title:    form.Title,
-		URLPath:  form.URLPath,
-		FilePath: "public/markdown/" + form.FilePath + ".md",
-	}
+		Title:       form.Title,
+		URLPath:     form.URLPath,
+		FilePath:    "public/markdown/" + form.FilePath + ".md",
+		Draft:       form.Draft,
+		PublishAt:   publishAt,
+		SeriesOrder: form.SeriesOrder,
+	}
+	post.SetSeries(form.Series)
 	if err := p.ps.Create(&post); err != nil {
 		vd.SetAlert(err)
 		p.New.Render(res, req, vd)
@@ -145,16 +304,7 @@ func (p *Posts) Create(res http.ResponseWriter, req *http.Request) {
 		log.Println(err)
 		http.Redirect(res, req, "/blog", http.StatusFound)
 		return
-  }
-  // Allows me to test pages locally without updating feeds
-  if (p.ps.IsProduction()) {
-    err = p.ps.MakePostsFeed()
-    if err != nil {
-      vd.SetAlert(err)
-      vd.RedirectAlert(res, req, url.Path, http.StatusFound, *vd.Alert)
-      return
-    }
-  }
+	}
 	http.Redirect(res, req, url.Path, http.StatusFound)
 }
 
@@ -165,11 +315,6 @@ func (p *Posts) Edit(res http.ResponseWriter, req *http.Request) {
 		// error handled by postByID
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to edit this post", http.StatusForbidden)
-		return
-	}
 	var vd views.Data
 	vd.Yield = post
 	p.EditView.Render(res, req, vd)
@@ -183,12 +328,6 @@ func (p *Posts) Update(res http.ResponseWriter, req *http.Request) {
 		// implemented by postByID
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to edit this post", http.StatusForbidden)
-		return
-	}
-
 	var vd views.Data
 	vd.Yield = post
 	var form PostForm
@@ -197,7 +336,17 @@ func (p *Posts) Update(res http.ResponseWriter, req *http.Request) {
 		p.EditView.Render(res, req, vd)
 		return
 	}
+	publishAt, err := form.publishAt()
+	if err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(res, req, vd)
+		return
+	}
 	post.Title = form.Title
+	post.Draft = form.Draft
+	post.PublishAt = publishAt
+	post.SetSeries(form.Series)
+	post.SeriesOrder = form.SeriesOrder
 	err = p.ps.Update(post)
 	if err != nil {
 		vd.SetAlert(err)
@@ -218,11 +367,6 @@ func (p *Posts) Delete(res http.ResponseWriter, req *http.Request) {
 		// postByID renders error
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to edit this post", http.StatusForbidden)
-		return
-	}
 	var vd views.Data
 	err = p.ps.Delete(post.ID)
 	if err != nil {
@@ -246,12 +390,6 @@ func (p *Posts) ImageUpload(res http.ResponseWriter, req *http.Request) {
 		// implemented by postByYearAndTitle
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to edit this post", http.StatusForbidden)
-		return
-	}
-
 	// Parse a multipart form
 	var vd views.Data
 	vd.Yield = post
@@ -296,11 +434,6 @@ func (p *Posts) ImageDelete(res http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		return
 	}
-	user := context.User(req.Context())
-	if user.IsAdmin != true {
-		http.Error(res, "You do not have permission to edit this post or image", http.StatusForbidden)
-		return
-	}
 	filename := mux.Vars(req)["filename"]
 	i := models.Image{
 		Filename: filename,
@@ -323,12 +456,139 @@ func (p *Posts) ImageDelete(res http.ResponseWriter, req *http.Request) {
 	http.Redirect(res, req, url.Path, http.StatusFound)
 }
 
+// HistoryPage holds the revisions of a post, and optionally the diff between two of them.
+type HistoryPage struct {
+	Post      *models.Post
+	Revisions []models.PostRevision
+	From      *models.PostRevision
+	To        *models.PostRevision
+	Diff      []diff.Line
+}
+
+// History : GET /posts/:id/history?from=:rev&to=:rev
+// — Lists the revisions of a post.  When two revisions are picked, shows a line diff between them.
+func (p *Posts) History(res http.ResponseWriter, req *http.Request) {
+	post, err := p.postByID(res, req)
+	if err != nil {
+		// postByID renders error
+		return
+	}
+	var vd views.Data
+	revs, err := p.ps.Revisions(post.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		vd.Yield = HistoryPage{Post: post}
+		p.HistoryView.Render(res, req, vd)
+		return
+	}
+	page := HistoryPage{
+		Post:      post,
+		Revisions: revs,
+	}
+	q := req.URL.Query()
+	page.From = findRevision(revs, q.Get("from"))
+	page.To = findRevision(revs, q.Get("to"))
+	if page.From != nil && page.To != nil {
+		page.Diff = diff.Lines(page.From.Content, page.To.Content)
+	}
+	vd.Yield = page
+	p.HistoryView.Render(res, req, vd)
+}
+
+// Restore : POST /posts/:id/revisions/:rev/restore
+func (p *Posts) Restore(res http.ResponseWriter, req *http.Request) {
+	post, err := p.postByID(res, req)
+	if err != nil {
+		// postByID renders error
+		return
+	}
+	var vd views.Data
+	revs, err := p.ps.Revisions(post.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		vd.Yield = HistoryPage{Post: post}
+		p.HistoryView.Render(res, req, vd)
+		return
+	}
+	rev := findRevision(revs, mux.Vars(req)["rev"])
+	if rev == nil {
+		http.Error(res, "Revision not found", http.StatusNotFound)
+		return
+	}
+	url, err := p.r.Get(PostHistory).URL("id", fmt.Sprintf("%v", post.ID))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(res, req, "/blog", http.StatusFound)
+		return
+	}
+	if err := p.ps.Restore(post, rev); err != nil {
+		vd.SetAlert(err)
+		vd.RedirectAlert(res, req, url.Path, http.StatusFound, *vd.Alert)
+		return
+	}
+	vd.RedirectAlert(res, req, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Post restored successfully!",
+	})
+}
+
+// SyncPage holds a sync report along with the render cache stats, which are shown on the same admin page.
+type SyncPage struct {
+	Report *models.SyncReport
+	Cache  models.CacheStats
+}
+
+// SyncPreview : GET /posts/sync
+// — Renders a dry run of a markdown sync, so that I can see what would change before committing to it.
+func (p *Posts) SyncPreview(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := SyncPage{Cache: p.ps.CacheStats()}
+	vd.Yield = page
+	report, err := p.ps.Sync(true)
+	if err != nil {
+		vd.SetAlert(err)
+		p.SyncView.Render(res, req, vd)
+		return
+	}
+	page.Report = report
+	vd.Yield = page
+	p.SyncView.Render(res, req, vd)
+}
+
+// Sync : POST /posts/sync
+// — Makes the posts table match the markdown on disk.
+func (p *Posts) Sync(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := SyncPage{Cache: p.ps.CacheStats()}
+	vd.Yield = page
+	report, err := p.ps.Sync(false)
+	if err != nil {
+		vd.SetAlert(err)
+		p.SyncView.Render(res, req, vd)
+		return
+	}
+	page.Report = report
+	vd.Yield = page
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Posts synced successfully!",
+	}
+	p.SyncView.Render(res, req, vd)
+}
+
 // #region HELPERS
 
 func (p *Posts) postByURL(res http.ResponseWriter, req *http.Request) (*models.Post, error) {
 	vars := mux.Vars(req)
 	urlpath := vars["urlpath"]
-	post, err := p.ps.ByURL(urlpath)
+	var post *models.Post
+	var err error
+	// Authors and up can open drafts and scheduled posts, everyone else only sees published posts.
+	if user := context.User(req.Context()); user != nil && user.Can(models.PermPostsDrafts) {
+		post, err = p.ps.ByURLWithDrafts(urlpath)
+	} else {
+		post, err = p.ps.ByURL(urlpath)
+	}
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
@@ -342,6 +602,82 @@ func (p *Posts) postByURL(res http.ResponseWriter, req *http.Request) (*models.P
 	return post, nil
 }
 
+// findRevision picks the revision with the given ID out of revs, or returns nil if there isn't one.
+func findRevision(revs []models.PostRevision, idVar string) *models.PostRevision {
+	id, err := strconv.Atoi(idVar)
+	if err != nil {
+		return nil
+	}
+	for i := range revs {
+		if revs[i].ID == uint(id) {
+			return &revs[i]
+		}
+	}
+	return nil
+}
+
+// postPage finds the posts that a post links to.
+func (p *Posts) postPage(post *models.Post) (PostPage, error) {
+	page := PostPage{Post: post}
+	if post.SeriesSlug != "" {
+		series, err := p.ps.BySeries(post.SeriesSlug)
+		if err != nil {
+			return page, err
+		}
+		for i := range series {
+			if series[i].ID != post.ID {
+				continue
+			}
+			page.Series = series
+			page.SeriesIndex = i
+			if i > 0 {
+				page.Prev = &series[i-1]
+			}
+			if i < len(series)-1 {
+				page.Next = &series[i+1]
+			}
+			return page, nil
+		}
+		// Drafts aren't part of their published series yet, so they fall through to the chronological links.
+	}
+	prev, next, err := p.ps.Adjacent(post)
+	if err != nil {
+		return page, err
+	}
+	page.Prev, page.Next = prev, next
+	return page, nil
+}
+
+// lastModified is when a page built from posts last changed: the latest of the last change to any post, and the rows and files of the posts on the page.
+func (p *Posts) lastModified(posts ...models.Post) time.Time {
+	t := p.ps.LastChanged()
+	for _, post := range posts {
+		if post.UpdatedAt.After(t) {
+			t = post.UpdatedAt
+		}
+		if post.ModTime.After(t) {
+			t = post.ModTime
+		}
+	}
+	return t
+}
+
+// paginate counts the published posts and builds the pagination for the ?page= of the request.  Pages past the end are not found.
+func (p *Posts) paginate(res http.ResponseWriter, req *http.Request, perPage int) (*views.Pagination, bool) {
+	total, err := p.ps.Count()
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return nil, false
+	}
+	pagination := views.NewPagination(req.URL, pageParam(req), perPage, total)
+	if !pagination.InRange() {
+		http.Error(res, "Page not found", http.StatusNotFound)
+		return nil, false
+	}
+	return pagination, true
+}
+
 func (p *Posts) postByLatest(res http.ResponseWriter, req *http.Request) (*models.Post, error) {
 	post, err := p.ps.ByLatest()
 	if err != nil {
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"nathanielwheeler.com/models"
+	"nathanielwheeler.com/views"
+)
+
+const maxSearchResults = 25
+
+// Search holds the view and service used to search posts.
+type Search struct {
+	SearchView *views.View
+	ss         models.SearchService
+}
+
+// NewSearch is a constructor for Search struct
+func NewSearch(ss models.SearchService) *Search {
+	return &Search{
+		SearchView: views.NewView("app", "search/results"),
+		ss:         ss,
+	}
+}
+
+// SearchPage holds a query and the posts it matched.
+type SearchPage struct {
+	Query   string
+	Results []models.SearchResult
+}
+
+// Search : GET /search?q=
+func (s *Search) Search(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	page := SearchPage{
+		Query: strings.TrimSpace(req.URL.Query().Get("q")),
+	}
+	if page.Query != "" {
+		results, err := s.ss.Search(page.Query, maxSearchResults)
+		if err != nil {
+			vd.SetAlert(err)
+		}
+		page.Results = results
+	}
+	vd.Yield = page
+	s.SearchView.Render(res, req, vd)
+}
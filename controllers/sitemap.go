@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nathanielwheeler.com/models"
+	"nathanielwheeler.com/views"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxSitemapURLs is the most URLs a single sitemap may hold.  Past it, /sitemap.xml becomes an index of numbered sitemaps.
+	maxSitemapURLs = 50000
+
+	sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// sitemapSkip lists static routes that aren't pages worth indexing.
+var sitemapSkip = map[string]bool{
+	"/cookietest":  true,
+	"/search":      true,
+	"/sitemap.xml": true,
+	"/robots.txt":  true,
+	"/login/2fa":   true,
+	"/reset":       true,
+}
+
+// sitemapSkipPrefixes lists path prefixes of routes that aren't pages worth indexing: prototypes, and pages that need a token or a signed in user.
+var sitemapSkipPrefixes = []string{
+	"/prototypes/",
+	"/account",
+	"/verify",
+	"/posts/",
+}
+
+// Sitemap tells search engines what to crawl.
+type Sitemap struct {
+	ps       models.PostsService
+	site     models.Site
+	r        *mux.Router
+	disallow []string
+}
+
+// NewSitemap is a constructor for Sitemap struct.  disallow lists the path prefixes that robots.txt keeps crawlers out of, which are left out of the sitemap too.
+func NewSitemap(ps models.PostsService, site models.Site, r *mux.Router, disallow []string) *Sitemap {
+	return &Sitemap{
+		ps:       ps,
+		site:     site,
+		r:        r,
+		disallow: disallow,
+	}
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// Sitemap : GET /sitemap.xml
+func (s *Sitemap) Sitemap(res http.ResponseWriter, req *http.Request) {
+	urls, ok := s.urls(res, req)
+	if !ok {
+		return
+	}
+	if len(urls) <= maxSitemapURLs {
+		writeXML(res, urlSet{Xmlns: sitemapNS, URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNS}
+	for n := 1; (n-1)*maxSitemapURLs < len(urls); n++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     s.site.URL(fmt.Sprintf("/sitemaps/%d.xml", n)),
+			Lastmod: latestLastmod(sitemapPage(urls, n)),
+		})
+	}
+	writeXML(res, index)
+}
+
+// SitemapPage : GET /sitemaps/:n.xml
+func (s *Sitemap) SitemapPage(res http.ResponseWriter, req *http.Request) {
+	n, err := strconv.Atoi(mux.Vars(req)["n"])
+	if err != nil || n < 1 {
+		http.Error(res, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+	urls, ok := s.urls(res, req)
+	if !ok {
+		return
+	}
+	page := sitemapPage(urls, n)
+	// Small sites only have /sitemap.xml.
+	if len(page) == 0 || len(urls) <= maxSitemapURLs {
+		http.Error(res, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+	writeXML(res, urlSet{Xmlns: sitemapNS, URLs: page})
+}
+
+// Robots : GET /robots.txt
+func (s *Sitemap) Robots(res http.ResponseWriter, req *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, path := range s.disallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s\n", s.site.URL("/sitemap.xml"))
+
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.Write([]byte(b.String()))
+}
+
+// urls lists the static routes followed by every published post.  It answers conditional requests itself, returning false whenever it has already written the response.
+func (s *Sitemap) urls(res http.ResponseWriter, req *http.Request) ([]sitemapURL, bool) {
+	lastChanged := s.ps.LastChanged()
+	res.Header().Set("Cache-Control", views.CachePublic)
+	if views.NotModified(res, req, lastChanged, views.ETag(lastChanged.UnixNano())) {
+		return nil, false
+	}
+
+	paths, err := s.staticPaths()
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return nil, false
+	}
+	entries, err := s.ps.SitemapEntries()
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	urls := make([]sitemapURL, 0, len(paths)+len(entries))
+	for _, path := range paths {
+		urls = append(urls, sitemapURL{Loc: s.site.URL(path)})
+	}
+	for _, entry := range entries {
+		urls = append(urls, sitemapURL{
+			Loc:     s.site.URL("/blog/" + entry.URLPath),
+			Lastmod: entry.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return urls, true
+}
+
+// staticPaths walks the router for GET routes without variables, leaving out anything robots.txt disallows.
+func (s *Sitemap) staticPaths() ([]string, error) {
+	seen := make(map[string]bool)
+	var paths []string
+	err := s.r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || seen[path] || sitemapSkip[path] || strings.Contains(path, "{") || hasAnyPrefix(path, sitemapSkipPrefixes) || hasAnyPrefix(path, s.disallow) {
+			return nil
+		}
+		// File servers are registered as prefixes without methods.
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, m := range methods {
+			if m == http.MethodGet {
+				seen[path] = true
+				paths = append(paths, path)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// hasAnyPrefix returns true if path starts with any of prefixes.
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// #region HELPERS
+
+// sitemapPage returns the nth (1-based) chunk of maxSitemapURLs URLs.
+func sitemapPage(urls []sitemapURL, n int) []sitemapURL {
+	start := (n - 1) * maxSitemapURLs
+	if start >= len(urls) {
+		return nil
+	}
+	end := start + maxSitemapURLs
+	if end > len(urls) {
+		end = len(urls)
+	}
+	return urls[start:end]
+}
+
+// latestLastmod returns the newest lastmod of a chunk of URLs.  Lastmods are RFC 3339 times in UTC, so they sort as strings.
+func latestLastmod(urls []sitemapURL) string {
+	var latest string
+	for _, u := range urls {
+		if u.Lastmod > latest {
+			latest = u.Lastmod
+		}
+	}
+	return latest
+}
+
+func writeXML(res http.ResponseWriter, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println(err)
+		http.Error(res, "Something bad happened.", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	res.Write([]byte(xml.Header))
+	res.Write(data)
+}
+
+// #endregion
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"nathanielwheeler.com/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSitemapStaticPaths(t *testing.T) {
+	r := mux.NewRouter()
+	ok := func(res http.ResponseWriter, req *http.Request) {}
+	for _, path := range []string{
+		"/", "/blog", "/resume", "/login", "/search",
+		"/prototypes/theme-system", "/account", "/account/2fa", "/verify", "/verify/pending", "/login/2fa", "/reset", "/posts/new",
+		"/drafts/secret", "/blog/{post}",
+	} {
+		r.HandleFunc(path, ok).Methods("GET")
+	}
+	r.HandleFunc("/register", ok).Methods("POST")
+
+	s := NewSitemap(nil, models.Site{}, r, []string{"/drafts/"})
+	paths, err := s.staticPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(paths, " "), "/ /blog /login /resume"; got != want {
+		t.Errorf("static paths = %s, want %s", got, want)
+	}
+}
@@ -1,29 +1,52 @@
 package controllers
 
 import (
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"nathanielwheeler.com/context"
+	"nathanielwheeler.com/email"
+	"nathanielwheeler.com/middleware"
 	"nathanielwheeler.com/models"
-	"nathanielwheeler.com/rand"
 	"nathanielwheeler.com/views"
 )
 
-// NewUsers initializes the view for users
-func NewUsers(us models.UserService) *Users {
+// pendingLoginCookie holds the signed token of a user that still has to enter their two-factor code.
+const pendingLoginCookie = "pending_login"
+
+// NewUsers initializes the view for users.  Mail is used to send password reset links, which point back to the site.
+func NewUsers(us models.UserService, ss models.SessionService, logins *models.LoginLimits, mailer email.Mailer, site models.Site) *Users {
 	return &Users{
-		RegisterView: views.NewView("app", "users/register"),
-		LoginView:    views.NewView("app", "users/login"),
-		us:           us,
+		RegisterView:  views.NewView("app", "users/register"),
+		LoginView:     views.NewView("app", "users/login"),
+		ForgotView:    views.NewView("app", "users/forgot"),
+		ResetView:     views.NewView("app", "users/reset"),
+		VerifyView:    views.NewView("app", "users/verify"),
+		TwoFactorView: views.NewView("app", "users/login2fa"),
+		us:            us,
+		ss:            ss,
+		logins:        logins,
+		mailer:        mailer,
+		site:          site,
 	}
 }
 
 // Users holds reference for the Users view and service.
 type Users struct {
-	RegisterView *views.View
-	LoginView    *views.View
-	us           models.UserService
+	RegisterView  *views.View
+	LoginView     *views.View
+	ForgotView    *views.View
+	ResetView     *views.View
+	VerifyView    *views.View
+	TwoFactorView *views.View
+	us            models.UserService
+	ss            models.SessionService
+	logins        *models.LoginLimits
+	mailer        email.Mailer
+	site          models.Site
 }
 
 // Registration : GET /register
@@ -59,12 +82,19 @@ func (u *Users) Register(res http.ResponseWriter, req *http.Request) {
 		u.RegisterView.Render(res, req, vd)
 		return
 	}
-	err := u.signIn(res, &user)
+	err := u.signIn(res, req, &user)
 	if err != nil {
 		http.Redirect(res, req, "/login", http.StatusFound)
 		return
 	}
-	http.Redirect(res, req, "/cookietest", http.StatusFound)
+	// The account is already made, so a failed email shouldn't stop them.  They can ask for another.
+	if err := u.sendVerification(&user); err != nil {
+		log.Println(err)
+	}
+	vd.RedirectAlert(res, req, "/cookietest", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlInfo,
+		Message: "Welcome!  A link to verify your email address is on its way.",
+	})
 }
 
 // LoginForm is used to transform a webform into a login request
@@ -83,11 +113,16 @@ func (u *Users) Login(res http.ResponseWriter, req *http.Request) {
 		u.LoginView.Render(res, req, vd)
 		return
 	}
+	ip := clientIP(req)
+	if u.tooManyLogins(&vd, form.Email, ip) {
+		u.LoginView.Render(res, req, vd)
+		return
+	}
 	user, err := u.us.Authenticate(form.Email, form.Password)
 	if err != nil {
 		switch err {
-		case models.ErrNotFound:
-		case models.ErrPasswordInvalid:
+		// Both get the same message, so the form doesn't give away which emails have an account.  The attempt was already counted as a failure.
+		case models.ErrNotFound, models.ErrPasswordInvalid:
 			vd.AlertError("Invalid email and/or password.")
 		default:
 			vd.SetAlert(err)
@@ -96,66 +131,377 @@ func (u *Users) Login(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = u.signIn(res, user)
+	// Users with two-factor authentication aren't signed in until they enter a code as well.  Their code is counted on its own.
+	if user.TwoFactor() {
+		if err := u.logins.Release(form.Email, ip); err != nil {
+			log.Println(err)
+		}
+		u.setPendingLogin(res, user)
+		http.Redirect(res, req, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	err = u.signIn(res, req, user)
+	if err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(res, req, vd)
+		return
+	}
+	if err := u.logins.Succeed(form.Email, ip); err != nil {
+		log.Println(err)
+	}
+	http.Redirect(res, req, "/cookietest", http.StatusFound)
+}
+
+// tooManyLogins records a login attempt for email from ip, counting it as a failure until it succeeds.  It sets an alert and returns true if logins for email from ip have failed too often to try again yet.
+func (u *Users) tooManyLogins(vd *views.Data, email, ip string) bool {
+	wait, err := u.logins.Attempt(email, ip)
 	if err != nil {
+		vd.SetAlert(err)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	vd.AlertError(fmt.Sprintf("Too many failed attempts.  Please try again in %s.", waitString(wait)))
+	return true
+}
+
+// waitString rounds a wait up to whole seconds or minutes, such as "4 seconds" or "15 minutes".
+func waitString(wait time.Duration) string {
+	if wait <= time.Minute {
+		n := int((wait + time.Second - 1) / time.Second)
+		if n == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", n)
+	}
+	n := int((wait + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%d minutes", n)
+}
+
+// TwoFactorForm is used to transform the two-factor webforms into requests.  Secret is only used when turning two-factor authentication on.
+type TwoFactorForm struct {
+	Secret string `schema:"secret"`
+	Code   string `schema:"code"`
+}
+
+// LoginTwoFactor : GET /login/2fa
+// — Asks a user that entered their password for a code from their authenticator app
+func (u *Users) LoginTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if _, err := u.pendingLogin(req); err != nil {
+		http.Redirect(res, req, "/login", http.StatusFound)
+		return
+	}
+	u.TwoFactorView.Render(res, req, nil)
+}
+
+// CompleteLogin : POST /login/2fa
+// — Signs in the user of the pending login, once they enter a code or a recovery code
+func (u *Users) CompleteLogin(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	user, err := u.pendingLogin(req)
+	if err != nil {
+		vd.RedirectAlert(res, req, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlWarning,
+			Message: "That took too long.  Please log in again.",
+		})
+		return
+	}
+	var form TwoFactorForm
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	// Codes are short, so they are limited the same way as passwords.
+	ip := clientIP(req)
+	if u.tooManyLogins(&vd, user.Email, ip) {
+		u.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	if err := u.us.CheckTOTP(user, form.Code); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(res, req, vd)
+		return
+	}
+	u.clearPendingLogin(res)
+	if err := u.signIn(res, req, user); err != nil {
 		vd.SetAlert(err)
 		u.LoginView.Render(res, req, vd)
 		return
 	}
+	if err := u.logins.Succeed(user.Email, ip); err != nil {
+		log.Println(err)
+	}
 	http.Redirect(res, req, "/cookietest", http.StatusFound)
 }
 
 // Logout : POST /logout
-// — Used to process the logout form when a user chooses to logout.
+// — Used to process the logout form when a user chooses to logout.  Only the session of this device ends.
 func (u *Users) Logout(res http.ResponseWriter, req *http.Request) {
-	// Expire user's cookie
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
-  }
-  http.SetCookie(res, &cookie)
-  // Update user with a new remember token
-  user := context.User(req.Context())
-  token, _ := rand.RememberToken() // Ignoring errors because because unlikely and not much to do about it.
-  user.Remember = token
-  u.us.Update(user)
+	u.expireSession(res)
+	user := context.User(req.Context())
+	if session := context.Session(req.Context()); session != nil {
+		if err := u.ss.Delete(user.ID, session.ID); err != nil {
+			log.Println(err)
+		}
+	}
+	http.Redirect(res, req, "/", http.StatusFound)
+}
 
-  http.Redirect(res, req, "/", http.StatusFound)
+// ResetPwForm is used to transform the forgot and reset password webforms into requests
+type ResetPwForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
 }
 
-// signIn is used to sign the given user in via cookies
-func (u *Users) signIn(res http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
-		}
-		user.Remember = token
-		err = u.us.Update(user)
+// resetEmail is the body of the email with a password reset link.
+const resetEmail = `Hi %s,
+
+Someone asked to reset the password of your account on %s.  If it was you, follow this link to choose a new password:
+
+%s
+
+The link works once, for the next hour.  If you didn't ask for this, you can ignore this email and your password will stay the same.
+`
+
+// Forgot : GET /forgot
+// — Renders a form asking for the email address of the account to reset
+func (u *Users) Forgot(res http.ResponseWriter, req *http.Request) {
+	u.ForgotView.Render(res, req, nil)
+}
+
+// InitiateReset : POST /forgot
+// — Mails a password reset link to the given email address.  The same message is shown whether or not there is an account for the address, so the form can't be used to find out who has one.
+func (u *Users) InitiateReset(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotView.Render(res, req, vd)
+		return
+	}
+	user, token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		link := u.site.URL("/reset?token=" + url.QueryEscape(token))
+		err = u.mailer.Send(email.Message{
+			To:      user.Email,
+			Subject: "Reset your password on " + u.site.Title,
+			Body:    fmt.Sprintf(resetEmail, user.Name, u.site.Title, link),
+		})
 		if err != nil {
-			return err
+			log.Println(err)
+			vd.AlertError(views.AlertMsgGeneric())
+			u.ForgotView.Render(res, req, vd)
+			return
 		}
+	// A reset that was sent too recently looks the same as any other, so the form can't be used to find out who has an account either.
+	case models.ErrNotFound, models.ErrResetTooSoon:
+	default:
+		vd.SetAlert(err)
+		u.ForgotView.Render(res, req, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "If there is an account for that email address, a link to reset its password is on its way.",
+	}
+	u.ForgotView.Render(res, req, vd)
+}
+
+// ResetPw : GET /reset
+// — Renders a form for choosing a new password, filling in the token from the link in the reset email
+func (u *Users) ResetPw(res http.ResponseWriter, req *http.Request) {
+	form := ResetPwForm{Token: req.URL.Query().Get("token")}
+	u.ResetView.Render(res, req, &form)
+}
+
+// CompleteReset : POST /reset
+// — Sets the new password of the user the token belongs to, then signs them in, or asks for their code first if they have two-factor authentication on
+func (u *Users) CompleteReset(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(res, req, vd)
+		return
+	}
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(res, req, vd)
+		return
+	}
+	// Whoever knew the old password may still be signed in somewhere.
+	if err := u.ss.DeleteByUser(user.ID); err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(res, req, vd)
+		return
+	}
+	// Reading the inbox of a user is not enough to get past their authenticator, so they still need a code.
+	if user.TwoFactor() {
+		u.setPendingLogin(res, user)
+		vd.RedirectAlert(res, req, "/login/2fa", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "Your password has been reset, and every other device has been signed out.  Please enter a code to sign in.",
+		})
+		return
+	}
+	if err := u.signIn(res, req, user); err != nil {
+		http.Redirect(res, req, "/login", http.StatusFound)
+		return
+	}
+	vd.RedirectAlert(res, req, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password has been reset, and every other device has been signed out.",
+	})
+}
+
+// verifyEmail is the body of the email with a verification link.
+const verifyEmail = `Hi %s,
+
+Thanks for signing up on %s!  Please follow this link to verify your email address:
+
+%s
+
+The link works for the next two days.  If you didn't sign up, you can ignore this email.
+`
+
+// Verify : GET /verify
+// — Verifies the email address of the user that the token in the link was made for
+func (u *Users) Verify(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	if _, err := u.us.VerifyEmail(req.URL.Query().Get("token")); err != nil {
+		vd.Yield = context.User(req.Context())
+		vd.SetAlert(err)
+		u.VerifyView.Render(res, req, vd)
+		return
+	}
+	vd.RedirectAlert(res, req, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Thanks!  Your email address is verified.",
+	})
+}
+
+// VerifyPending : GET /verify/pending
+// — Asks the user to verify their email address, with a button to send a new link
+func (u *Users) VerifyPending(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	if user.Verified() {
+		http.Redirect(res, req, "/", http.StatusFound)
+		return
+	}
+	u.VerifyView.Render(res, req, user)
+}
+
+// ResendVerification : POST /verify/resend
+// — Sends the user a new verification link.  Links can only be sent every few minutes.
+func (u *Users) ResendVerification(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	if user.Verified() {
+		http.Redirect(res, req, "/", http.StatusFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = user
+	if err := u.sendVerification(user); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(res, req, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "A new link is on its way to " + user.Email + ".",
+	}
+	u.VerifyView.Render(res, req, vd)
+}
+
+// sendVerification mails the user a link to verify their email address.
+func (u *Users) sendVerification(user *models.User) error {
+	token, err := u.us.StartVerification(user)
+	if err != nil {
+		return err
+	}
+	link := u.site.URL("/verify?token=" + url.QueryEscape(token))
+	return u.mailer.Send(email.Message{
+		To:      user.Email,
+		Subject: "Verify your email address on " + u.site.Title,
+		Body:    fmt.Sprintf(verifyEmail, user.Name, u.site.Title, link),
+	})
+}
+
+// signIn is used to sign the given user in on this device, starting a new session and setting its cookie
+func (u *Users) signIn(res http.ResponseWriter, req *http.Request, user *models.User) error {
+	session, err := u.ss.Start(user, req.UserAgent(), clientIP(req))
+	if err != nil {
+		return err
 	}
 	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
+		Name:     middleware.SessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(res, &cookie)
 	return nil
 }
 
-// CookieTest is used to display cookies set on the current user
-func (u *Users) CookieTest(res http.ResponseWriter, req *http.Request) {
-	cookie, err := req.Cookie("remember_token")
-	if err != nil {
-		http.Redirect(res, req, "/login", http.StatusFound)
-		return
+// setPendingLogin remembers a user that entered their password, until they enter their code.  The cookie is signed, so it can't be made up, and only lasts a few minutes.
+func (u *Users) setPendingLogin(res http.ResponseWriter, user *models.User) {
+	cookie := http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    u.us.PendingLogin(user),
+		Path:     "/login",
+		MaxAge:   int(5 * time.Minute / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
-	_, err = u.us.ByRemember(cookie.Value)
+	http.SetCookie(res, &cookie)
+}
+
+// pendingLogin returns the user of the pending login cookie.
+func (u *Users) pendingLogin(req *http.Request) (*models.User, error) {
+	cookie, err := req.Cookie(pendingLoginCookie)
 	if err != nil {
+		return nil, err
+	}
+	return u.us.ByPendingLogin(cookie.Value)
+}
+
+// clearPendingLogin removes the pending login cookie from the browser.
+func (u *Users) clearPendingLogin(res http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+	http.SetCookie(res, &cookie)
+}
+
+// expireSession removes the session cookie from the browser.
+func (u *Users) expireSession(res http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now(),
+		HttpOnly: true,
+	}
+	http.SetCookie(res, &cookie)
+}
+
+// CookieTest is used to check that the session cookie was set on the current user
+func (u *Users) CookieTest(res http.ResponseWriter, req *http.Request) {
+	if context.User(req.Context()) == nil {
 		http.Redirect(res, req, "/login", http.StatusFound)
 		return
 	}
@@ -0,0 +1,127 @@
+package diff
+
+import "strings"
+
+// Op says what happened to a line between two texts.
+type Op int
+
+const (
+	// Equal lines are in both texts.
+	Equal Op = iota
+	// Insert lines are only in the new text.
+	Insert
+	// Delete lines are only in the old text.
+	Delete
+)
+
+// Line is a single line of a diff.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// IsInsert is a helper for templates.
+func (l Line) IsInsert() bool { return l.Op == Insert }
+
+// IsDelete is a helper for templates.
+func (l Line) IsDelete() bool { return l.Op == Delete }
+
+// maxTableCells caps the size of the table Lines builds for the lines that changed, which is about 8 MB.  Anything bigger is shown as every changed line deleted and then inserted again.
+const maxTableCells = 1 << 20
+
+// Lines compares two texts line by line using their longest common subsequence.  Deletions come before insertions wherever lines were replaced.  Lines that both texts start or end with are kept out of the comparison, so that a small edit to a long post stays cheap.
+func Lines(a, b string) []Line {
+	as := splitLines(a)
+	bs := splitLines(b)
+
+	prefix := 0
+	for prefix < len(as) && prefix < len(bs) && as[prefix] == bs[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(as)-prefix && suffix < len(bs)-prefix && as[len(as)-1-suffix] == bs[len(bs)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(as)+len(bs)-prefix-suffix)
+	for _, text := range as[:prefix] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	lines = append(lines, middle(as[prefix:len(as)-suffix], bs[prefix:len(bs)-suffix])...)
+	for _, text := range as[len(as)-suffix:] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	return lines
+}
+
+// middle compares the lines between the common prefix and suffix of two texts.
+func middle(as, bs []string) []Line {
+	lines := make([]Line, 0, len(as)+len(bs))
+	if (len(as)+1)*(len(bs)+1) > maxTableCells {
+		for _, text := range as {
+			lines = append(lines, Line{Op: Delete, Text: text})
+		}
+		for _, text := range bs {
+			lines = append(lines, Line{Op: Insert, Text: text})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:] and bs[j:].
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(as) && j < len(bs) {
+		switch {
+		case as[i] == bs[j]:
+			lines = append(lines, Line{Op: Equal, Text: as[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: Delete, Text: as[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: bs[j]})
+			j++
+		}
+	}
+	for ; i < len(as); i++ {
+		lines = append(lines, Line{Op: Delete, Text: as[i]})
+	}
+	for ; j < len(bs); j++ {
+		lines = append(lines, Line{Op: Insert, Text: bs[j]})
+	}
+	return lines
+}
+
+// Changed returns true if any line was inserted or deleted.
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// render writes a diff the way unified diffs do, with a space, "+" or "-" in front of each line.
+func render(lines []Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		switch l.Op {
+		case Insert:
+			b.WriteString("+")
+		case Delete:
+			b.WriteString("-")
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(l.Text + "\n")
+	}
+	return b.String()
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", " a\n b\n"},
+		{"empty", "", "", ""},
+		{"added", "", "a\n", "+a\n"},
+		{"removed", "a\n", "", "-a\n"},
+		{"replaced", "a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"inserted", "a\nc\n", "a\nb\nc\n", " a\n+b\n c\n"},
+		{"moved", "a\nb\nc\nd\n", "b\nc\na\nd\n", "-a\n b\n c\n+a\n d\n"},
+		{"repeated", "a\na\n", "a\na\na\n", " a\n a\n+a\n"},
+		{"line endings", "a\r\nb\r\n", "a\nb\n", " a\n b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(Lines(tt.a, tt.b)); got != tt.want {
+				t.Errorf("Lines(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// numbered returns n lines, each holding its own number after prefix.
+func numbered(prefix string, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%s%d\n", prefix, i)
+	}
+	return b.String()
+}
+
+func TestLinesLongPost(t *testing.T) {
+	// A small edit in the middle of a long post only compares the lines that changed.
+	post := numbered("line ", 5000)
+	edited := strings.Replace(post, "line 2500\n", "line 2500, edited\n", 1)
+	lines := Lines(post, edited)
+	var changed []string
+	for _, l := range lines {
+		if l.Op != Equal {
+			changed = append(changed, render([]Line{l}))
+		}
+	}
+	if got, want := strings.Join(changed, ""), "-line 2500\n+line 2500, edited\n"; got != want {
+		t.Errorf("changed lines =\n%s\nwant\n%s", got, want)
+	}
+	if len(lines) != 5001 {
+		t.Errorf("got %d lines, want 5001", len(lines))
+	}
+}
+
+func TestLinesOverBudget(t *testing.T) {
+	// Rewriting all of a long post is too big to compare, so every line is replaced.
+	a := "same\n" + numbered("old ", 2000) + "end\n"
+	b := "same\n" + numbered("new ", 2000) + "end\n"
+	lines := Lines(a, b)
+	if len(lines) != 4002 {
+		t.Fatalf("got %d lines, want 4002", len(lines))
+	}
+	if lines[0].Op != Equal || lines[len(lines)-1].Op != Equal {
+		t.Error("the common first and last lines should be kept")
+	}
+	for i, l := range lines[1:2001] {
+		if l.Op != Delete {
+			t.Fatalf("line %d is %v, want a deletion", i+1, l.Op)
+		}
+	}
+	for i, l := range lines[2001:4001] {
+		if l.Op != Insert {
+			t.Fatalf("line %d is %v, want an insertion", i+2001, l.Op)
+		}
+	}
+}
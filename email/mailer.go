@@ -0,0 +1,110 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nathanielwheeler.com/rand"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.  Everything in the app that mails readers goes through it, so that the transport can be swapped for an outbox during development and testing.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// #region SMTP
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer is the constructor of a Mailer that sends through an SMTP server.  Username and password may be left empty for servers that don't need to authenticate.
+func NewSMTPMailer(host string, port int, username, password, from string) Mailer {
+	m := smtpMailer{
+		addr: fmt.Sprintf("%s:%d", host, port),
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &m
+}
+
+// Send delivers msg to the SMTP server.
+func (m *smtpMailer) Send(msg Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+}
+
+// #endregion
+
+// #region OUTBOX
+
+type outboxMailer struct {
+	dir  string
+	from string
+}
+
+// NewOutboxMailer is the constructor of a Mailer that writes every message into dir as an .eml file instead of sending it.  Any mail client can open the files, so mail can be checked without a mail server.
+func NewOutboxMailer(dir, from string) Mailer {
+	return &outboxMailer{dir: dir, from: from}
+}
+
+// Send writes msg into the outbox.  File names start with the time they were written, so the newest message sorts last.
+func (m *outboxMailer) Send(msg Message) error {
+	now := time.Now()
+	data, err := format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	id, err := rand.UUID()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405"), id[:8])
+	return ioutil.WriteFile(filepath.Join(m.dir, name), data, 0644)
+}
+
+// #endregion
+
+// format builds the headers and body of msg.  Newlines in the headers are refused, so that a reader can't add headers of their own through a form field.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, h := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, fmt.Errorf("email: header %q holds a newline", h)
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
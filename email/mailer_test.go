@@ -0,0 +1,90 @@
+package email
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "outbox")
+	m := NewOutboxMailer(dir, "site@example.com")
+	msg := Message{
+		To:      "reader@example.com",
+		Subject: "Reset your password",
+		Body:    "Hi,\nFollow this link.\n",
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files in the outbox, want 2", len(files))
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		"From: site@example.com\r\n",
+		"To: reader@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nHi,\r\nFollow this link.\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message is missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestFormatRefusesHeaderNewlines(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		msg  Message
+	}{
+		{"to", "site@example.com", Message{To: "reader@example.com\r\nBcc: everyone@example.com", Subject: "Hi"}},
+		{"subject", "site@example.com", Message{To: "reader@example.com", Subject: "Hi\nBcc: everyone@example.com"}},
+		{"from", "site@example.com\rBcc: everyone@example.com", Message{To: "reader@example.com", Subject: "Hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := format(tt.from, tt.msg, time.Now()); err == nil {
+				t.Error("got no error, want one")
+			}
+		})
+	}
+}
+
+func TestOutboxMailerRefusesHeaderNewlines(t *testing.T) {
+	dir := tempDir(t)
+	m := NewOutboxMailer(dir, "site@example.com")
+	err := m.Send(Message{To: "reader@example.com\nBcc: everyone@example.com", Subject: "Hi"})
+	if err == nil {
+		t.Fatal("got no error, want one")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 0 {
+		t.Errorf("got %d files in the outbox, want none", len(files))
+	}
+}
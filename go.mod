@@ -17,4 +17,5 @@ require (
 	github.com/yuin/goldmark-meta v1.0.0
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
 	gopkg.in/yaml.v2 v2.3.0
+	rsc.io/qr v0.2.0
 )
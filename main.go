@@ -3,12 +3,15 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"nathanielwheeler.com/config"
 	"nathanielwheeler.com/controllers"
+	"nathanielwheeler.com/email"
 	"nathanielwheeler.com/middleware"
 	"nathanielwheeler.com/models"
 	"nathanielwheeler.com/rand"
+	"nathanielwheeler.com/views"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -22,28 +25,88 @@ func main() {
 	cfg := config.LoadConfig()
 	dbCfg := cfg.Database
 
+	site := models.Site{
+		Title:       cfg.Site.Title,
+		BaseURL:     cfg.Site.BaseURL,
+		Author:      cfg.Site.Author,
+		Email:       cfg.Site.Email,
+		Description: cfg.Site.Description,
+	}
+	views.SetSite(site)
+
+	feedSettings := models.FeedSettings{
+		ExportDir: cfg.Feeds.ExportDir,
+		HubURL:    cfg.Feeds.HubURL,
+		Mode:      models.FeedFull,
+	}
+	if cfg.Feeds.Summary {
+		feedSettings.Mode = models.FeedSummary
+	}
+
+	// Mail goes out through SMTP when a server is configured, and into the outbox otherwise.
+	mailer := email.NewOutboxMailer(cfg.Mail.OutboxDir, cfg.Mail.From)
+	if cfg.Mail.SMTP.Host != "" {
+		smtpCfg := cfg.Mail.SMTP
+		mailer = email.NewSMTPMailer(smtpCfg.Host, smtpCfg.Port, smtpCfg.User, smtpCfg.Password, cfg.Mail.From)
+	}
+
 	// Initialize services
 	services, err := models.NewServices(
+		models.WithSite(site),
 		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionString()),
 		models.WithLogMode(!cfg.IsProd()),
 		models.WithUser(cfg.Pepper, cfg.HMACKey),
+		models.WithSessions(cfg.HMACKey),
+		models.WithLoginLimits(cfg.Auth.MemoryLoginLimits),
 		models.WithPosts(cfg.IsProd()),
 		models.WithImages(),
+		models.WithSearch(),
+		models.WithFeeds(feedSettings),
+		models.WithWebSub(cfg.Feeds.HubSecret),
 	)
 	defer services.Close()
 	services.AutoMigrate()
 
+	if cfg.SyncOnStart {
+		report, err := services.Posts.Sync(false)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Synced posts: %d changed, %d unchanged.\n", len(report.Changes), report.Unchanged)
+		for _, e := range report.Errors {
+			fmt.Println("  sync error:", e)
+		}
+	}
+
+	// Publish scheduled posts as their time comes
+	stopScheduler := services.Posts.StartScheduler(time.Minute)
+	defer stopScheduler()
+
 	// Router Initialization
 	r := mux.NewRouter()
 
 	// Initialize controllers
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User)
+	usersC := controllers.NewUsers(services.User, services.Sessions, services.Logins, mailer, services.Site)
+	accountC := controllers.NewAccount(services.User, services.Sessions, services.Site, cfg.Auth.AdminTwoFactor)
 	postsC := controllers.NewPosts(services.Posts, services.Images, r)
+	searchC := controllers.NewSearch(services.Search)
+	feedsC := controllers.NewFeeds(services.Feeds)
+	sitemapC := controllers.NewSitemap(services.Posts, services.Site, r, cfg.Robots.Disallow)
 
 	// Middleware
-	userMw := middleware.User{UserService: services.User}
+	userMw := middleware.User{
+		UserService: services.User,
+		Sessions:    services.Sessions,
+	}
 	requireUserMw := middleware.RequireUser{}
+	twoFactorMw := middleware.RequireTwoFactor{Privileged: cfg.Auth.AdminTwoFactor}
+	createPostsMw := middleware.RequirePermission(models.PermPostsCreate)
+	editPostsMw := middleware.RequirePermission(models.PermPostsEdit)
+	deletePostsMw := middleware.RequirePermission(models.PermPostsDelete)
+	syncPostsMw := middleware.RequirePermission(models.PermPostsSync)
+	uploadImagesMw := middleware.RequirePermission(models.PermImagesUpload)
+	deleteImagesMw := middleware.RequirePermission(models.PermImagesDelete)
 
 	// CSRF Protection
 	b, err := rand.Bytes(cfg.CSRFBytes)
@@ -59,8 +122,6 @@ func main() {
 	r.PathPrefix("/stylesheets/").
 		Handler(publicHandler)
 	r.PathPrefix("/markdown/").
-    Handler(publicHandler)
-  r.PathPrefix("/feeds/").
     Handler(publicHandler)
 
 	// Statics Routes
@@ -71,6 +132,17 @@ func main() {
     staticC.PrototypeThemeSystem).
     Methods("GET")
 
+	// Crawler Routes
+	r.HandleFunc("/robots.txt",
+		sitemapC.Robots).
+		Methods("GET")
+	r.HandleFunc("/sitemap.xml",
+		sitemapC.Sitemap).
+		Methods("GET")
+	r.HandleFunc("/sitemaps/{n:[0-9]+}.xml",
+		sitemapC.SitemapPage).
+		Methods("GET")
+
 	// User Routes
 	r.HandleFunc("/register",
 		usersC.Registration).
@@ -84,9 +156,54 @@ func main() {
 	r.HandleFunc("/login",
 		usersC.Login).
     Methods("POST")
+	r.HandleFunc("/login/2fa",
+		usersC.LoginTwoFactor).
+		Methods("GET")
+	r.HandleFunc("/login/2fa",
+		usersC.CompleteLogin).
+		Methods("POST")
   r.Handle("/logout",
     requireUserMw.ApplyFn(usersC.Logout)).
     Methods("POST")
+	r.HandleFunc("/forgot",
+		usersC.Forgot).
+		Methods("GET")
+	r.HandleFunc("/forgot",
+		usersC.InitiateReset).
+		Methods("POST")
+	r.HandleFunc("/reset",
+		usersC.ResetPw).
+		Methods("GET")
+	r.HandleFunc("/reset",
+		usersC.CompleteReset).
+		Methods("POST")
+	r.HandleFunc("/verify",
+		usersC.Verify).
+		Methods("GET")
+	r.HandleFunc("/verify/pending",
+		requireUserMw.ApplyFn(usersC.VerifyPending)).
+		Methods("GET")
+	r.HandleFunc("/verify/resend",
+		requireUserMw.ApplyFn(usersC.ResendVerification)).
+		Methods("POST")
+	r.HandleFunc("/account",
+		requireUserMw.ApplyFn(accountC.Show)).
+		Methods("GET")
+	r.HandleFunc("/account/sessions/{id:[0-9]+}/revoke",
+		requireUserMw.ApplyFn(accountC.RevokeSession)).
+		Methods("POST")
+	r.HandleFunc("/account/sessions/revoke",
+		requireUserMw.ApplyFn(accountC.RevokeAll)).
+		Methods("POST")
+	r.HandleFunc("/account/2fa",
+		requireUserMw.ApplyFn(accountC.TwoFactor)).
+		Methods("GET")
+	r.HandleFunc("/account/2fa/enable",
+		requireUserMw.ApplyFn(accountC.EnableTwoFactor)).
+		Methods("POST")
+	r.HandleFunc("/account/2fa/disable",
+		requireUserMw.ApplyFn(accountC.DisableTwoFactor)).
+		Methods("POST")
 	r.HandleFunc("/cookietest",
 		usersC.CookieTest).
 		Methods("GET")
@@ -100,33 +217,75 @@ func main() {
     postsC.BlogIndex).
     Methods("GET").
     Name(controllers.BlogIndexRoute)
+  r.HandleFunc("/blog/tags",
+    postsC.BlogTags).
+    Methods("GET").
+    Name(controllers.BlogTagsRoute)
+  r.HandleFunc(`/blog/tags/{tag:[a-z0-9\-]+}`,
+    postsC.BlogTag).
+    Methods("GET").
+    Name(controllers.BlogTagRoute)
+  r.HandleFunc(`/blog/series/{slug:[a-z0-9\-]+}`,
+    postsC.BlogSeries).
+    Methods("GET").
+    Name(controllers.BlogSeriesRoute)
   r.HandleFunc(`/blog/{urlpath:[a-zA-Z0-9\/\-_~.]+}`,
     postsC.BlogPost).
     Methods("GET").
     Name(controllers.BlogPostRoute)
+  //    Search
+  r.HandleFunc("/search",
+    searchC.Search).
+    Methods("GET")
+  //    Feeds
+  r.HandleFunc("/feeds/feed.{format:atom|rss|json}",
+    feedsC.Site).
+    Methods("GET")
+  r.HandleFunc(`/feeds/tags/{tag:[a-z0-9\-]+}.{format:atom|rss|json}`,
+    feedsC.Tag).
+    Methods("GET")
+  r.HandleFunc(`/feeds/series/{slug:[a-z0-9\-]+}.{format:atom|rss|json}`,
+    feedsC.Series).
+    Methods("GET")
+  r.HandleFunc("/feeds/{year:[0-9]{4}}.{format:atom|rss|json}",
+    feedsC.Year).
+    Methods("GET")
   //    API / Admin
 	r.HandleFunc("/posts",
-		requireUserMw.ApplyFn(postsC.Create)).
+		createPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Create))).
 		Methods("POST")
 	r.Handle("/posts/new",
-		requireUserMw.Apply(postsC.New)).
+		createPostsMw.Apply(twoFactorMw.Apply(postsC.New))).
+		Methods("GET")
+	r.HandleFunc("/posts/sync",
+		syncPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.SyncPreview))).
 		Methods("GET")
+	r.HandleFunc("/posts/sync",
+		syncPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Sync))).
+		Methods("POST")
 	r.HandleFunc("/posts/{id:[0-9]+}/edit",
-		requireUserMw.ApplyFn(postsC.Edit)).
+		editPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Edit))).
 		Methods("GET").
 		Name(controllers.EditPost)
 	r.HandleFunc("/posts/{id:[0-9]+}/update",
-		requireUserMw.ApplyFn(postsC.Update)).
+		editPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Update))).
+		Methods("POST")
+	r.HandleFunc("/posts/{id:[0-9]+}/history",
+		editPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.History))).
+		Methods("GET").
+		Name(controllers.PostHistory)
+	r.HandleFunc("/posts/{id:[0-9]+}/revisions/{rev:[0-9]+}/restore",
+		editPostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Restore))).
 		Methods("POST")
 	r.HandleFunc("/posts/{id:[0-9]+}/delete",
-		requireUserMw.ApplyFn(postsC.Delete)).
+		deletePostsMw.ApplyFn(twoFactorMw.ApplyFn(postsC.Delete))).
 		Methods("POST")
 		//    Images
 	r.HandleFunc("/posts/{id:[0-9]+}/upload",
-		requireUserMw.ApplyFn(postsC.ImageUpload)).
+		uploadImagesMw.ApplyFn(twoFactorMw.ApplyFn(postsC.ImageUpload))).
 		Methods("POST")
 	r.HandleFunc("/posts/{id:[0-9]+}/image/{filename}/delete",
-		requireUserMw.ApplyFn(postsC.ImageDelete)).
+		deleteImagesMw.ApplyFn(twoFactorMw.ApplyFn(postsC.ImageDelete))).
     Methods("POST")
 
 	// Start that server!
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+  "log"
   "net/http"
   "strings"
 
@@ -8,9 +9,13 @@ import (
   "nathanielwheeler.com/models"
 )
 
-// User middleware will lookup the current user via their remember token cookie using the UserService.  If found, they will be set on the request context.  Either way, the next handler is always called.
+// SessionCookie is the name of the cookie that holds the session token of a signed in user.
+const SessionCookie = "session_token"
+
+// User middleware will lookup the current user via their session cookie using the SessionService and UserService.  If found, they and their session will be set on the request context.  Either way, the next handler is always called.
 type User struct {
   models.UserService
+  Sessions models.SessionService
 }
 
 // Apply will allow http.Handler interfaces to be handled by middleware by applying ServeHTTP to the handler and passing it into ApplyFn
@@ -18,7 +23,7 @@ func (mw *User) Apply(next http.Handler) http.HandlerFunc {
   return mw.ApplyFn(next.ServeHTTP)
 }
 
-// ApplyFn will take in an http.HandlerFunc and run middleware that will check for a remember token cookie and set it to the request context.  It will always call the next handler.
+// ApplyFn will take in an http.HandlerFunc and run middleware that will check for a session cookie and set its user to the request context.  It will always call the next handler.
 func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
   return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
     path := req.URL.Path
@@ -27,18 +32,28 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
       next(res, req)
       return
     }
-    cookie, err := req.Cookie("remember_token")
+    cookie, err := req.Cookie(SessionCookie)
+    if err != nil {
+      next(res, req)
+      return
+    }
+    session, err := mw.Sessions.ByToken(cookie.Value)
     if err != nil {
       next(res, req)
       return
     }
-    user, err := mw.UserService.ByRemember(cookie.Value)
+    user, err := mw.UserService.ByID(session.UserID)
     if err != nil {
       next(res, req)
       return
     }
+    // Failing to record when the session was seen shouldn't stop the request.
+    if err := mw.Sessions.Touch(session); err != nil {
+      log.Println(err)
+    }
     ctx := req.Context()
     ctx = context.WithUser(ctx, user)
+    ctx = context.WithSession(ctx, session)
     req = req.WithContext(ctx)
     next(res, req)
   })
@@ -52,7 +67,7 @@ func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
   return mw.ApplyFn(next.ServeHTTP)
 }
 
-// ApplyFn will take in an http.HandlerFunc and run middleware that will check for a remember token cookie.  If there is no user in context, the user will be redirected to /login.
+// ApplyFn will take in an http.HandlerFunc and run middleware that will check for a signed in user.  If there is no user in context, the user will be redirected to /login.
 func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
   return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
     user := context.User(req.Context())
@@ -63,3 +78,84 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
     next(res, req)
   })
 }
+
+// PermissionCheck will redirect a user to /login if they are not logged in, and refuse them if their role doesn't grant its permission.  Permissions that need a verified email address, such as commenting, also go through RequireVerified.  It is made with RequirePermission, and assumes that User middleware has already been run.
+type PermissionCheck struct {
+  perm models.Permission
+}
+
+// RequirePermission returns middleware that only lets through users whose role grants perm.  It takes the place of RequireUser on the routes it guards.
+func RequirePermission(perm models.Permission) *PermissionCheck {
+  return &PermissionCheck{perm: perm}
+}
+
+// Apply will allow http.Handler interfaces to be handled by middleware by applying ServeHTTP to the handler and passing it into ApplyFn
+func (mw *PermissionCheck) Apply(next http.Handler) http.HandlerFunc {
+  return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn will take in an http.HandlerFunc and run middleware that will check that the user in context can do what the route needs.  Users without the permission get a 403.
+func (mw *PermissionCheck) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+  return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    user := context.User(req.Context())
+    if user == nil {
+      http.Redirect(res, req, "/login", http.StatusFound)
+      return
+    }
+    if !user.Can(mw.perm) {
+      http.Error(res, "You do not have permission to access this page", http.StatusForbidden)
+      return
+    }
+    if mw.perm.NeedsVerified() {
+      (&RequireVerified{}).ApplyFn(next)(res, req)
+      return
+    }
+    next(res, req)
+  })
+}
+
+// RequireVerified will send a user to /verify/pending until they have verified their email address, and to /login if they are not logged in.  It should guard anything that mails a user or lets them post where others can read it.  This middleware assumes that User middleware has already been run.
+type RequireVerified struct{}
+
+// Apply will allow http.Handler interfaces to be handled by middleware by applying ServeHTTP to the handler and passing it into ApplyFn
+func (mw *RequireVerified) Apply(next http.Handler) http.HandlerFunc {
+  return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn will take in an http.HandlerFunc and run middleware that will check that the user in context has a verified email address.
+func (mw *RequireVerified) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+  return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    user := context.User(req.Context())
+    if user == nil {
+      http.Redirect(res, req, "/login", http.StatusFound)
+      return
+    }
+    if !user.Verified() {
+      http.Redirect(res, req, "/verify/pending", http.StatusFound)
+      return
+    }
+    next(res, req)
+  })
+}
+
+// RequireTwoFactor will send users whose role can change posts or images to /account/2fa until they turn on two-factor authentication, when Privileged is set.  It should wrap every admin route.  This middleware assumes that User middleware has already been run.
+type RequireTwoFactor struct {
+  Privileged bool
+}
+
+// Apply will allow http.Handler interfaces to be handled by middleware by applying ServeHTTP to the handler and passing it into ApplyFn
+func (mw *RequireTwoFactor) Apply(next http.Handler) http.HandlerFunc {
+  return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn will take in an http.HandlerFunc and run middleware that will check that a privileged user in context has two-factor authentication turned on.
+func (mw *RequireTwoFactor) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+  return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    user := context.User(req.Context())
+    if mw.Privileged && user != nil && user.Role.Privileged() && !user.TwoFactor() {
+      http.Redirect(res, req, "/account/2fa", http.StatusFound)
+      return
+    }
+    next(res, req)
+  })
+}
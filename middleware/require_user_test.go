@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nathanielwheeler.com/context"
+	"nathanielwheeler.com/models"
+)
+
+// serve runs a request through handler as user, or signed out when user is nil, and returns the response.
+func serve(handler http.HandlerFunc, user *models.User) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/posts/hello/comments", nil)
+	if user != nil {
+		req = req.WithContext(context.WithUser(req.Context(), user))
+	}
+	res := httptest.NewRecorder()
+	handler(res, req)
+	return res
+}
+
+func TestRequireVerified(t *testing.T) {
+	verified := time.Now()
+	ok := func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNoContent)
+	}
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		user     *models.User
+		status   int
+		location string
+	}{
+		{"signed out", (&RequireVerified{}).ApplyFn(ok), nil, http.StatusFound, "/login"},
+		{"unverified", (&RequireVerified{}).ApplyFn(ok), &models.User{Role: models.RoleReader}, http.StatusFound, "/verify/pending"},
+		{"verified", (&RequireVerified{}).ApplyFn(ok), &models.User{Role: models.RoleReader, EmailVerifiedAt: &verified}, http.StatusNoContent, ""},
+		// Commenting needs a verified address as well as the role.
+		{"comment as reader", RequirePermission(models.PermCommentsCreate).ApplyFn(ok), &models.User{Role: models.RoleReader, EmailVerifiedAt: &verified}, http.StatusForbidden, ""},
+		{"comment unverified", RequirePermission(models.PermCommentsCreate).ApplyFn(ok), &models.User{Role: models.RoleCommenter}, http.StatusFound, "/verify/pending"},
+		{"comment verified", RequirePermission(models.PermCommentsCreate).ApplyFn(ok), &models.User{Role: models.RoleCommenter, EmailVerifiedAt: &verified}, http.StatusNoContent, ""},
+		// Other permissions don't ask for one.
+		{"edit unverified", RequirePermission(models.PermPostsEdit).ApplyFn(ok), &models.User{Role: models.RoleAuthor}, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serve(tt.handler, tt.user)
+			if res.Code != tt.status {
+				t.Errorf("status = %d, want %d", res.Code, tt.status)
+			}
+			if got := res.Header().Get("Location"); got != tt.location {
+				t.Errorf("redirected to %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
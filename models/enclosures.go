@@ -0,0 +1,162 @@
+package models
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// remoteImageTimeout is how long measuring an image hosted elsewhere may take before its feed gives up on its length.
+const remoteImageTimeout = 5 * time.Second
+
+// leadImage picks the image that represents a post in feeds: the Image key of its front matter, or else the first image uploaded for the post.  It returns nil when the post has neither.
+func (fs *feedsService) leadImage(post Post) *feeds.Enclosure {
+	if ref := metaString(post.MetaData, "Image"); ref != "" {
+		return fs.enclosure(ref)
+	}
+	images, err := fs.is.ByPostID(post.ID)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	for _, img := range images {
+		if enc := fs.enclosure(img.Path()); enc != nil && strings.HasPrefix(enc.Type, "image/") {
+			return enc
+		}
+	}
+	return nil
+}
+
+// enclosure describes the file at ref, which is either a path on the site or an absolute URL.  Files on the site are measured on disk below the public directory.  Files hosted elsewhere are looked up in what measureRemote found.
+func (fs *feedsService) enclosure(ref string) *feeds.Enclosure {
+	u, err := url.Parse(ref)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if fs.isRemote(u) {
+		return fs.remoteEnclosure(u)
+	}
+
+	urlPath := path.Clean("/" + u.Path)
+	file := filepath.Join("public", filepath.FromSlash(urlPath))
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	typ := mime.TypeByExtension(filepath.Ext(file))
+	if typ == "" {
+		if typ, err = sniffType(file); err != nil {
+			log.Println(err)
+			return nil
+		}
+	}
+	return &feeds.Enclosure{
+		Url:    fs.site.URL((&url.URL{Path: urlPath}).EscapedPath()),
+		Type:   typ,
+		Length: strconv.FormatInt(info.Size(), 10),
+	}
+}
+
+// isRemote returns true if u is an absolute URL on a host other than the site.
+func (fs *feedsService) isRemote(u *url.URL) bool {
+	if !u.IsAbs() {
+		return false
+	}
+	base, err := url.Parse(fs.site.BaseURL)
+	return err != nil || u.Host != base.Host
+}
+
+// measureRemote measures the lead images that the posts of filter have on other hosts.  Images that were measured before are skipped, unless refresh is set.  It must not be called while holding buildMu, since each image can take up to remoteImageTimeout.
+func (fs *feedsService) measureRemote(filter FeedFilter, refresh bool) {
+	posts, err := fs.posts(filter)
+	if err != nil {
+		// build will run into the same error and report it.
+		return
+	}
+	for _, post := range posts {
+		if err := fs.ps.ParseMD(&post); err != nil {
+			continue
+		}
+		u, err := url.Parse(metaString(post.MetaData, "Image"))
+		if err != nil || !fs.isRemote(u) {
+			continue
+		}
+		fs.remoteMu.Lock()
+		_, ok := fs.remote[u.String()]
+		fs.remoteMu.Unlock()
+		if ok && !refresh {
+			continue
+		}
+		enc := fs.measure(u)
+		fs.remoteMu.Lock()
+		fs.remote[enc.Url] = enc
+		fs.remoteMu.Unlock()
+	}
+}
+
+// remoteEnclosure returns what measureRemote found for an image elsewhere.  An image that hasn't been measured gets no length, like one whose host didn't answer.
+func (fs *feedsService) remoteEnclosure(u *url.URL) *feeds.Enclosure {
+	fs.remoteMu.Lock()
+	enc, ok := fs.remote[u.String()]
+	fs.remoteMu.Unlock()
+	if !ok {
+		log.Printf("feeds: %s has not been measured, RSS will leave it out", u)
+		return &feeds.Enclosure{Url: u.String(), Type: mime.TypeByExtension(path.Ext(u.Path))}
+	}
+	return enc
+}
+
+// measure asks the host of an image elsewhere for its length and type.  When it can't get a length, it logs a warning and returns the enclosure without one: RSS needs a length, so it leaves the image out, but Atom and JSON Feed still link to it.
+func (fs *feedsService) measure(u *url.URL) *feeds.Enclosure {
+	enc := &feeds.Enclosure{
+		Url:  u.String(),
+		Type: mime.TypeByExtension(path.Ext(u.Path)),
+	}
+
+	res, err := fs.client.Head(enc.Url)
+	if err != nil {
+		log.Printf("feeds: can't measure %s, RSS will leave it out: %v", enc.Url, err)
+		return enc
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Printf("feeds: can't measure %s, RSS will leave it out: %s", enc.Url, res.Status)
+		return enc
+	}
+	if typ, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		enc.Type = typ
+	}
+	length, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		log.Printf("feeds: %s has no Content-Length, RSS will leave it out", enc.Url)
+		return enc
+	}
+	enc.Length = strconv.FormatInt(length, 10)
+	return enc
+}
+
+// sniffType guesses the MIME type of a file without a known extension from its first bytes.
+func sniffType(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := f.Read(head)
+	if err != nil && n == 0 {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
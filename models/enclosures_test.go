@@ -0,0 +1,140 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryFeedPosts is a PostsService that only knows the published posts of the site-wide feed, with their front matter.
+type memoryFeedPosts struct {
+	PostsService
+	posts []Post
+	meta  map[string]map[string]interface{}
+}
+
+func (ps *memoryFeedPosts) GetAll() ([]Post, error) {
+	return ps.posts, nil
+}
+
+func (ps *memoryFeedPosts) ParseMD(post *Post) error {
+	post.MetaData = ps.meta[post.URLPath]
+	return nil
+}
+
+func TestMeasureRemote(t *testing.T) {
+	var mu sync.Mutex
+	var heads int
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			t.Errorf("image was fetched with %s, want HEAD", req.Method)
+		}
+		mu.Lock()
+		heads++
+		mu.Unlock()
+		switch req.URL.Path {
+		case "/lead.jpg":
+			res.Header().Set("Content-Type", "image/webp; charset=binary")
+			res.Header().Set("Content-Length", "2048")
+		case "/streamed.png":
+			// Flushing before writing makes the response chunked, so it has no length.
+			res.(http.Flusher).Flush()
+		default:
+			http.NotFound(res, req)
+		}
+	}))
+	defer srv.Close()
+
+	ps := &memoryFeedPosts{
+		posts: []Post{{URLPath: "measured"}, {URLPath: "no-length"}, {URLPath: "missing"}},
+		meta: map[string]map[string]interface{}{
+			"measured":  {"Image": srv.URL + "/lead.jpg"},
+			"no-length": {"Image": srv.URL + "/streamed.png"},
+			"missing":   {"Image": srv.URL + "/missing.gif"},
+		},
+	}
+	fs := NewFeedsService(ps, nil, Site{BaseURL: "https://example.com"}, FeedSettings{}).(*feedsService)
+
+	// Nothing is asked while a feed is built, so images that weren't measured have no length yet.
+	if enc := fs.enclosure(srv.URL + "/lead.jpg"); enc == nil || enc.Length != "" {
+		t.Errorf("unmeasured enclosure = %+v, want one without a length", enc)
+	}
+	if heads != 0 {
+		t.Fatalf("got %d HEAD requests while building, want none", heads)
+	}
+
+	fs.measureRemote(FeedFilter{}, false)
+	tests := []struct {
+		name   string
+		ref    string
+		typ    string
+		length string
+	}{
+		{"measured", srv.URL + "/lead.jpg", "image/webp", "2048"},
+		{"no length", srv.URL + "/streamed.png", "image/png", ""},
+		{"missing", srv.URL + "/missing.gif", "image/gif", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := fs.enclosure(tt.ref)
+			if enc == nil {
+				t.Fatal("got no enclosure, want one for Atom and JSON Feed")
+			}
+			if enc.Url != tt.ref || enc.Type != tt.typ || enc.Length != tt.length {
+				t.Errorf("enclosure = %+v, want type %q and length %q", *enc, tt.typ, tt.length)
+			}
+		})
+	}
+
+	// Images are only measured again on a rebuild.
+	fs.measureRemote(FeedFilter{}, false)
+	if heads != len(tests) {
+		t.Errorf("got %d HEAD requests, want %d", heads, len(tests))
+	}
+	fs.measureRemote(FeedFilter{}, true)
+	if heads != 2*len(tests) {
+		t.Errorf("got %d HEAD requests after refreshing, want %d", heads, 2*len(tests))
+	}
+}
+
+func TestSlowImageHostDoesNotHoldBuilds(t *testing.T) {
+	asked := make(chan struct{})
+	answer := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		close(asked)
+		<-answer
+	}))
+	defer srv.Close()
+
+	ps := &memoryFeedPosts{
+		posts: []Post{{URLPath: "slow"}},
+		meta:  map[string]map[string]interface{}{"slow": {"Image": srv.URL + "/lead.jpg"}},
+	}
+	fs := NewFeedsService(ps, nil, Site{BaseURL: "https://example.com"}, FeedSettings{}).(*feedsService)
+	done := make(chan error)
+	go func() { done <- fs.Rebuild() }()
+
+	<-asked
+	locked := make(chan struct{})
+	go func() {
+		fs.buildMu.Lock()
+		fs.buildMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("buildMu was held while waiting on the image host")
+	}
+	close(answer)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL + "/lead.jpg")
+	if enc := fs.remoteEnclosure(u); enc.Type != "image/jpeg" {
+		t.Errorf("enclosure = %+v, want the measured image", *enc)
+	}
+}
@@ -19,10 +19,21 @@ const (
 	// TODO password validator for max length (64)
 	// TODO password validator for restricted characters in password
 
-	errRememberRequired modelError = "models: remember token required"
-  errRememberTooShort modelError = "models: remember token should be at least 32 bytes"
+	ErrTokenInvalid modelError = "models: this link is invalid or has expired"
+	ErrResetTooSoon modelError = "models: a password reset email was sent a few minutes ago, please check your inbox before asking for another"
+
+	ErrVerifyTooSoon   modelError = "models: a verification email was sent a few minutes ago, please check your inbox before asking for another"
+	errAlreadyVerified modelError = "models: email address is already verified"
+
+	ErrCodeInvalid  modelError = "models: that code is not valid, or has already been used"
+	errTwoFactorOn  modelError = "models: two-factor authentication is already on"
+	errTwoFactorOff modelError = "models: two-factor authentication is not on"
+
+	errRoleInvalid modelError = "models: role should be reader, commenter, author, editor or admin"
 
 	errTitleRequired modelError = "models: title is required"
+
+	ErrPublishAtInvalid modelError = "models: publish date should look like 2020-10-31T09:00"
 )
 
 type modelError string
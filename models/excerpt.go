@@ -0,0 +1,108 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	// moreMarker ends the excerpt of a post when it shows up in the markdown.
+	moreMarker = "<!--more-->"
+	// wordsPerMinute is the reading speed used to estimate reading time.
+	wordsPerMinute = 200
+	// maxExcerptLen is the longest an excerpt taken from the first paragraph may be, in characters.
+	maxExcerptLen = 300
+)
+
+// readingStats are computed for every post when its markdown is rendered.
+type readingStats struct {
+	wordCount   int
+	readingTime int
+	excerpt     string
+}
+
+// computeReadingStats counts the words of a rendered document and picks its excerpt.  The excerpt is everything before a <!--more--> marker, or else the first paragraph, as plain text.
+func computeReadingStats(p parser.Parser, doc ast.Node, source []byte) readingStats {
+	words := len(strings.Fields(nodeText(doc, source)))
+	stats := readingStats{
+		wordCount:   words,
+		readingTime: (words + wordsPerMinute - 1) / wordsPerMinute,
+	}
+	if stats.readingTime < 1 {
+		stats.readingTime = 1
+	}
+
+	if i := bytes.Index(source, []byte(moreMarker)); i >= 0 {
+		head := source[:i]
+		headDoc := p.Parse(text.NewReader(head), parser.WithContext(parser.NewContext()))
+		stats.excerpt = collapseSpaces(nodeText(headDoc, head))
+		return stats
+	}
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if n.Kind() == ast.KindParagraph {
+			stats.excerpt = truncateWords(collapseSpaces(nodeText(n, source)), maxExcerptLen)
+			break
+		}
+	}
+	return stats
+}
+
+// nodeText gets the plain text of a node and its children.  Markup and raw HTML are dropped, and blocks are separated by newlines.
+func nodeText(node ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Text:
+			if entering {
+				buf.Write(n.Segment.Value(source))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					buf.WriteByte(' ')
+				}
+			}
+		case *ast.String:
+			if entering {
+				buf.Write(n.Value)
+			}
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if entering {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					line := lines.At(i)
+					buf.Write(line.Value(source))
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		default:
+			if !entering && n.Type() == ast.TypeBlock {
+				buf.WriteByte('\n')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return buf.String()
+}
+
+// collapseSpaces joins the words of s with single spaces.
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncateWords cuts s down to at most max characters, breaking between words and adding an ellipsis.
+func truncateWords(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)[:max]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ",.;:!? ") + "…"
+}
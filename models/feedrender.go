@@ -0,0 +1,191 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/feeds"
+)
+
+// atomNS is the namespace RSS feeds borrow atom:link from.
+const atomNS = "http://www.w3.org/2005/Atom"
+
+var (
+	// openTagRegex matches the opening tags of rendered HTML.  Markup inside code blocks is escaped, so it is never matched.
+	openTagRegex = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+	// urlAttrRegex matches href and src attributes, with their value in either kind of quotes.
+	urlAttrRegex = regexp.MustCompile(`\b(href|src)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// feedItem turns a post that has been through ParseMD into a feed item, identified by its GUID.  Descriptions are HTML in Atom and RSS, so the plain text excerpt is escaped before it is used as one.
+func (fs *feedsService) feedItem(post Post, mode FeedMode) *feeds.Item {
+	title := post.Title
+	if t := metaString(post.MetaData, "Title"); t != "" {
+		title = t
+	}
+	link := fs.site.URL("/blog/" + post.URLPath)
+	// Front matter can say when a post was last meaningfully updated, so that fixing a typo doesn't have to resurface it in readers.
+	updated, ok := metaTime(post.MetaData, "Updated")
+	if !ok {
+		updated = post.UpdatedAt
+	}
+	item := &feeds.Item{
+		Id:      post.GUID,
+		Title:   title,
+		Link:    &feeds.Link{Href: link},
+		Created: post.CreatedAt,
+		Updated: updated,
+		// Atom and RSS read the lead image from here, and gorilla/feeds turns it into the image of JSON Feed items.
+		Enclosure: fs.leadImage(post),
+	}
+	switch mode {
+	case FeedSummary:
+		item.Description = summaryHTML(post.Excerpt, link)
+	default:
+		item.Description = html.EscapeString(post.Excerpt)
+		item.Content = absoluteURLs(post.Body, link)
+	}
+	return item
+}
+
+// summaryHTML is the excerpt of a post followed by a link to the rest of it.
+func summaryHTML(excerpt, link string) string {
+	return "<p>" + html.EscapeString(excerpt) + `</p><p><a href="` + html.EscapeString(link) + `">Read more…</a></p>`
+}
+
+// absoluteURLs resolves the relative href and src attributes of rendered HTML against base, the URL of the page the HTML came from.  Feed readers show posts away from the site, where relative links have nothing to resolve against.
+func absoluteURLs(body, base string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return body
+	}
+	return openTagRegex.ReplaceAllStringFunc(body, func(tag string) string {
+		return urlAttrRegex.ReplaceAllStringFunc(tag, func(attr string) string {
+			m := urlAttrRegex.FindStringSubmatch(attr)
+			value := m[2]
+			if strings.HasSuffix(attr, "'") {
+				value = m[3]
+			}
+			ref, err := url.Parse(strings.TrimSpace(html.UnescapeString(value)))
+			if err != nil || ref.IsAbs() {
+				return attr
+			}
+			return m[1] + `="` + html.EscapeString(baseURL.ResolveReference(ref).String()) + `"`
+		})
+	})
+}
+
+// render renders a feed in every format.  gorilla/feeds can't link a feed to a WebSub hub, so the hub and self links are added to its output.  excerpts holds the plain text excerpt of each item, for the summaries of JSON Feed.
+func (fs *feedsService) render(feed *feeds.Feed, filter FeedFilter, excerpts []string) (*FeedSnapshot, error) {
+	atom, err := feed.ToAtom()
+	if err != nil {
+		return nil, err
+	}
+	rss, err := feed.ToRss()
+	if err != nil {
+		return nil, err
+	}
+	jsonFeed := newJSONFeed(feed)
+	// JSON Feed summaries are plain text, and every item needs content.
+	for i, item := range jsonFeed.Items {
+		item.Summary = excerpts[i]
+		if item.ContentHTML == "" {
+			item.ContentHTML = feed.Items[i].Description
+		}
+		if enc := feed.Items[i].Enclosure; enc != nil {
+			size, _ := strconv.ParseInt(enc.Length, 10, 64)
+			item.Attachments = []jsonAttachment{{
+				URL:         enc.Url,
+				MIMEType:    enc.Type,
+				SizeInBytes: size,
+			}}
+		}
+	}
+
+	// GUIDs are URNs rather than links, which RSS has to be told.
+	rss = strings.Replace(rss, "<guid>", `<guid isPermaLink="false">`, -1)
+
+	if hub := fs.settings.HubURL; hub != "" {
+		atom = insertAfterTag(atom, "<feed",
+			xmlLink("  ", "link", fs.Topic(filter, FeedAtom), "self")+
+				xmlLink("  ", "link", hub, "hub"))
+		rss = strings.Replace(rss, "<rss ", `<rss xmlns:atom="`+atomNS+`" `, 1)
+		rss = insertAfterTag(rss, "<channel",
+			xmlLink("    ", "atom:link", fs.Topic(filter, FeedRSS), "self")+
+				xmlLink("    ", "atom:link", hub, "hub"))
+		jsonFeed.FeedUrl = fs.Topic(filter, FeedJSON)
+		jsonFeed.Hubs = []*feeds.JSONHub{{Type: "WebSub", Url: hub}}
+	}
+
+	data, err := json.MarshalIndent(jsonFeed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	json := string(data)
+
+	h := sha1.New()
+	for _, s := range []string{atom, rss, json} {
+		io.WriteString(h, s)
+	}
+	return &FeedSnapshot{
+		Atom:    []byte(atom),
+		RSS:     []byte(rss),
+		JSON:    []byte(json),
+		Updated: feed.Updated,
+		Hash:    hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// jsonFeed fixes the parts of JSON Feed that gorilla/feeds v1.1.1 gets wrong: hubs are declared as items, and attachments name their size "size" rather than "size_in_bytes".  The outer fields hide the embedded ones when marshalled.
+type jsonFeed struct {
+	*feeds.JSONFeed
+	Hubs  []*feeds.JSONHub `json:"hubs,omitempty"`
+	Items []*jsonItem      `json:"items,omitempty"`
+}
+
+type jsonItem struct {
+	*feeds.JSONItem
+	Attachments []jsonAttachment `json:"attachments,omitempty"`
+}
+
+type jsonAttachment struct {
+	URL         string `json:"url"`
+	MIMEType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func newJSONFeed(feed *feeds.Feed) *jsonFeed {
+	jf := &jsonFeed{
+		JSONFeed: (&feeds.JSON{Feed: feed}).JSONFeed(),
+	}
+	for _, item := range jf.JSONFeed.Items {
+		jf.Items = append(jf.Items, &jsonItem{JSONItem: item})
+	}
+	return jf
+}
+
+// xmlLink builds a link element for an XML feed, on its own line.
+func xmlLink(indent, name, href, rel string) string {
+	return "\n" + indent + "<" + name + ` href="` + html.EscapeString(href) + `" rel="` + rel + `"></` + name + ">"
+}
+
+// insertAfterTag inserts s right after the opening tag that starts with open, leaving the document as it is if there is no such tag.
+func insertAfterTag(doc, open, s string) string {
+	i := strings.Index(doc, open)
+	if i < 0 {
+		return doc
+	}
+	end := strings.Index(doc[i:], ">")
+	if end < 0 {
+		return doc
+	}
+	end += i + 1
+	return doc[:end] + s + doc[end:]
+}
@@ -0,0 +1,359 @@
+package models
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// FeedFormat is one of the formats a feed is served in.
+type FeedFormat string
+
+const (
+	// FeedAtom is an Atom 1.0 feed.
+	FeedAtom FeedFormat = "atom"
+	// FeedRSS is an RSS 2.0 feed.
+	FeedRSS FeedFormat = "rss"
+	// FeedJSON is a JSON Feed 1.0 feed.
+	FeedJSON FeedFormat = "json"
+)
+
+// FeedSnapshot holds a feed rendered in every format.  Snapshots are never modified once they are built, so they can be shared between requests.
+type FeedSnapshot struct {
+	Atom []byte
+	RSS  []byte
+	JSON []byte
+	// Updated is when the newest post in the feed last changed.
+	Updated time.Time
+	// Hash identifies the content of the snapshot, so that it can be used as an entity tag.
+	Hash string
+}
+
+// Format returns the snapshot rendered in the given format.
+func (fs *FeedSnapshot) Format(format FeedFormat) []byte {
+	switch format {
+	case FeedAtom:
+		return fs.Atom
+	case FeedRSS:
+		return fs.RSS
+	case FeedJSON:
+		return fs.JSON
+	}
+	return nil
+}
+
+// FeedMode is how much of each post a feed holds.
+type FeedMode string
+
+const (
+	// FeedFull puts the whole body of each post in the feed.
+	FeedFull FeedMode = "full"
+	// FeedSummary only puts the excerpt of each post in the feed, with a link to read the rest on the site.
+	FeedSummary FeedMode = "summary"
+)
+
+// FeedSettings configures FeedsService.
+type FeedSettings struct {
+	// ExportDir is where every rebuild of the site-wide feed is also written as feed.atom, feed.rss and feed.json, for static hosting.  Nothing is written when it is empty.
+	ExportDir string
+	// HubURL is the WebSub hub that every feed advertises, if any.
+	HubURL string
+	// Mode is used by feeds that don't ask for one.  It defaults to FeedFull.
+	Mode FeedMode
+}
+
+// feedKey identifies a snapshot.
+type feedKey struct {
+	filter FeedFilter
+	mode   FeedMode
+}
+
+// feedFormats lists every format a feed is rendered in.
+var feedFormats = []FeedFormat{FeedAtom, FeedRSS, FeedJSON}
+
+// FeedFilter narrows a feed down to the posts of a single tag, series or year.  The zero value is the site-wide feed.
+type FeedFilter struct {
+	Tag    string
+	Series string
+	Year   int
+}
+
+// Path is the URL path of the feed, without the extension of its format.
+func (f FeedFilter) Path() string {
+	switch {
+	case f.Tag != "":
+		return "/feeds/tags/" + f.Tag
+	case f.Series != "":
+		return "/feeds/series/" + f.Series
+	case f.Year != 0:
+		return "/feeds/" + strconv.Itoa(f.Year)
+	}
+	return "/feeds/feed"
+}
+
+// feedFilters returns the site-wide filter, followed by every tag, series and year that the posts belong to.
+func feedFilters(posts []Post) []FeedFilter {
+	filters := []FeedFilter{{}}
+	seen := map[FeedFilter]bool{{}: true}
+	add := func(f FeedFilter) {
+		if !seen[f] {
+			seen[f] = true
+			filters = append(filters, f)
+		}
+	}
+	for _, post := range posts {
+		for _, tag := range post.Tags {
+			add(FeedFilter{Tag: tag.Slug})
+		}
+		if post.SeriesSlug != "" {
+			add(FeedFilter{Series: post.SeriesSlug})
+		}
+		add(FeedFilter{Year: post.CreatedAt.Year()})
+	}
+	return filters
+}
+
+// FeedsService keeps the site feeds in memory.  They are rebuilt whenever posts change, rather than on every request.
+type FeedsService interface {
+	// Snapshot returns the feed for a filter in a mode, building it first if it hasn't been built yet.  An empty mode uses the default of the settings.  Filters that match no posts return ErrNotFound.
+	Snapshot(filter FeedFilter, mode FeedMode) (*FeedSnapshot, error)
+	// Rebuild builds the site-wide feed from the published posts and throws away every filtered feed, so that they are built again when they are next asked for.
+	Rebuild() error
+	// Hub returns the URL of the WebSub hub that the feeds advertise, or an empty string if there is none.
+	Hub() string
+	// Topic returns the absolute URL of a feed in a format, which is the topic WebSub subscribers know it by.
+	Topic(filter FeedFilter, format FeedFormat) string
+	// Topics returns the topic of every feed that has posts, in every format, as of the last rebuild.  Only feeds in the default mode are topics.
+	Topics() []string
+}
+
+type feedsService struct {
+	ps       PostsService
+	is       ImagesService
+	site     Site
+	settings FeedSettings
+
+	// buildMu keeps two builds of the same feed from racing to store their snapshots.
+	buildMu   sync.Mutex
+	mu        sync.RWMutex
+	snapshots map[feedKey]*FeedSnapshot
+	topics    []string
+
+	// client measures images hosted elsewhere before buildMu is taken, so that a slow host never holds up a build.  What it finds is kept in remote, which is measured again on every rebuild.
+	client   *http.Client
+	remoteMu sync.Mutex
+	remote   map[string]*feeds.Enclosure
+}
+
+// NewFeedsService is the constructor of FeedsService.
+func NewFeedsService(ps PostsService, is ImagesService, site Site, settings FeedSettings) FeedsService {
+	if settings.Mode == "" {
+		settings.Mode = FeedFull
+	}
+	return &feedsService{
+		ps:        ps,
+		is:        is,
+		site:      site,
+		settings:  settings,
+		snapshots: make(map[feedKey]*FeedSnapshot),
+		client:    &http.Client{Timeout: remoteImageTimeout},
+		remote:    make(map[string]*feeds.Enclosure),
+	}
+}
+
+func (fs *feedsService) Snapshot(filter FeedFilter, mode FeedMode) (*FeedSnapshot, error) {
+	if mode == "" {
+		mode = fs.settings.Mode
+	}
+	key := feedKey{filter, mode}
+	fs.mu.RLock()
+	snap, ok := fs.snapshots[key]
+	fs.mu.RUnlock()
+	if ok {
+		return snap, nil
+	}
+
+	fs.measureRemote(filter, false)
+	fs.buildMu.Lock()
+	defer fs.buildMu.Unlock()
+	// Another request may have built it while this one waited.
+	fs.mu.RLock()
+	snap, ok = fs.snapshots[key]
+	fs.mu.RUnlock()
+	if ok {
+		return snap, nil
+	}
+	snap, _, err := fs.build(filter, mode)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.snapshots[key] = snap
+	fs.mu.Unlock()
+	return snap, nil
+}
+
+func (fs *feedsService) Rebuild() error {
+	fs.measureRemote(FeedFilter{}, true)
+	fs.buildMu.Lock()
+	defer fs.buildMu.Unlock()
+
+	snap, posts, err := fs.build(FeedFilter{}, fs.settings.Mode)
+	if err != nil {
+		return err
+	}
+	var topics []string
+	for _, filter := range feedFilters(posts) {
+		for _, format := range feedFormats {
+			topics = append(topics, fs.Topic(filter, format))
+		}
+	}
+	fs.mu.Lock()
+	fs.snapshots = map[feedKey]*FeedSnapshot{{mode: fs.settings.Mode}: snap}
+	fs.topics = topics
+	fs.mu.Unlock()
+
+	if fs.settings.ExportDir != "" {
+		if err := exportFeed(fs.settings.ExportDir, snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *feedsService) Hub() string {
+	return fs.settings.HubURL
+}
+
+func (fs *feedsService) Topic(filter FeedFilter, format FeedFormat) string {
+	return fs.site.URL(filter.Path() + "." + string(format))
+}
+
+func (fs *feedsService) Topics() []string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.topics
+}
+
+// build looks up the posts of a filter and renders their feed.  The posts are returned along with it.
+func (fs *feedsService) build(filter FeedFilter, mode FeedMode) (*FeedSnapshot, []Post, error) {
+	feed := &feeds.Feed{
+		Title:       fs.site.Title,
+		Link:        &feeds.Link{Href: fs.site.URL("/")},
+		Description: fs.site.Description,
+		Author:      &feeds.Author{Name: fs.site.Author, Email: fs.site.Email},
+	}
+
+	posts, err := fs.posts(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case filter.Tag != "":
+		tag, err := fs.ps.TagBySlug(filter.Tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		feed.Title += ": " + tag.Name
+		feed.Link.Href = fs.site.URL("/blog/tags/" + tag.Slug)
+	case filter.Series != "":
+		feed.Title += ": " + posts[0].Series
+		feed.Link.Href = fs.site.URL("/blog/series/" + filter.Series)
+	case filter.Year != 0:
+		feed.Title += ": " + strconv.Itoa(filter.Year)
+		feed.Link.Href = fs.site.URL("/blog")
+	}
+
+	excerpts := make([]string, 0, len(posts))
+	for _, post := range posts {
+		if err := fs.ps.ParseMD(&post); err != nil {
+			log.Println(err)
+		}
+		item := fs.feedItem(post, mode)
+		for _, t := range []time.Time{post.CreatedAt, post.UpdatedAt, post.ModTime, item.Updated} {
+			if t.After(feed.Updated) {
+				feed.Updated = t
+			}
+		}
+		feed.Items = append(feed.Items, item)
+		excerpts = append(excerpts, post.Excerpt)
+	}
+	// The feed only changes when its posts do, so that unchanged feeds keep their entity tag across rebuilds.
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+	feed.Created = feed.Updated
+
+	snap, err := fs.render(feed, filter, excerpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snap, posts, nil
+}
+
+// posts looks up the published posts of a filter.  An empty site feed is fine for a new blog, but an empty filtered feed is a typo, so it returns ErrNotFound.
+func (fs *feedsService) posts(filter FeedFilter) ([]Post, error) {
+	var posts []Post
+	var err error
+	switch {
+	case filter.Tag != "":
+		posts, err = fs.ps.ByTag(filter.Tag)
+	case filter.Series != "":
+		posts, err = fs.ps.BySeries(filter.Series)
+	case filter.Year != 0:
+		posts, err = fs.ps.ByYear(filter.Year)
+	default:
+		posts, err = fs.ps.GetAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 && filter != (FeedFilter{}) {
+		return nil, ErrNotFound
+	}
+	return posts, nil
+}
+
+// exportFeed writes every format of a snapshot into dir.  Each file is written to a temporary file first and renamed over the old one, so that a static file server never serves half a feed.
+func exportFeed(dir string, snap *FeedSnapshot) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, format := range feedFormats {
+		if err := writeFileAtomic(filepath.Join(dir, "feed."+string(format)), snap.Format(format)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, using a temporary file in the same directory and a rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once the file has been renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
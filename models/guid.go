@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"nathanielwheeler.com/rand"
+)
+
+// newGUID builds a permanent identifier for a post, as a UUID URN.
+func newGUID() (string, error) {
+	uuid, err := rand.UUID()
+	if err != nil {
+		return "", err
+	}
+	return "urn:uuid:" + uuid, nil
+}
+
+// backfillGUIDs gives every post without a GUID a new one, including soft-deleted posts in case they are restored.  Only the guid column is written, so that UpdatedAt doesn't mark every post as edited.
+func backfillGUIDs(db *gorm.DB) error {
+	var posts []Post
+	if err := db.Unscoped().Select("id").Where("guid IS NULL OR guid = ''").Find(&posts).Error; err != nil {
+		return err
+	}
+	for _, post := range posts {
+		guid, err := newGUID()
+		if err != nil {
+			return err
+		}
+		if err := db.Unscoped().Model(&post).UpdateColumn("guid", guid).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
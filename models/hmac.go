@@ -0,0 +1,8 @@
+package models
+
+import "nathanielwheeler.com/hash"
+
+// hmacWith hashes s with an HMAC keyed by key.  A hash.HMAC keeps its state between calls, so sharing one between request goroutines would mix up their hashes.  A new one is made for every call instead.
+func hmacWith(key, s string) string {
+	return hash.NewHMAC(key).Hash(s)
+}
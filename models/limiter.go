@@ -0,0 +1,243 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LimitPolicy describes how a LoginLimiter slows down guessing.  The first Free failures cost nothing.  After that, each failure has to wait Delay, doubling with every further failure, until the wait reaches Lockout and the key is locked out for that long.
+type LimitPolicy struct {
+	Free    int
+	Delay   time.Duration
+	Lockout time.Duration
+	// Forget is how long after its last failure the failures of a key are forgotten.  It should be longer than Lockout.
+	Forget time.Duration
+}
+
+var (
+	// AccountPolicy is meant for failures against one email address.  Waits start after three wrong passwords, and reach the fifteen minute lockout after fourteen.
+	AccountPolicy = LimitPolicy{Free: 3, Delay: time.Second, Lockout: 15 * time.Minute, Forget: time.Hour}
+	// IPPolicy is meant for failures from one client.  It allows more, since many people can share an address.
+	IPPolicy = LimitPolicy{Free: 10, Delay: time.Second, Lockout: 15 * time.Minute, Forget: time.Hour}
+)
+
+// wait returns how long a key with failures, the last of which was at last, has to wait at now.
+func (p LimitPolicy) wait(failures int, last, now time.Time) time.Duration {
+	if failures <= p.Free || now.Sub(last) > p.Forget {
+		return 0
+	}
+	delay := p.Lockout
+	// Shifting by much more than this would overflow, and it's past any sensible lockout anyway.
+	if n := failures - p.Free - 1; n < 32 {
+		if d := p.Delay << uint(n); d < p.Lockout {
+			delay = d
+		}
+	}
+	if remaining := last.Add(delay).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// LoginLimiter counts logins by key, such as an email address or an IP address, and says how long a key has to wait before it can try again.  Every attempt is counted as a failure before it is checked, so that a burst of attempts at the same time can't all get in before any of them fails.
+type LoginLimiter interface {
+	// Attempt records an attempt of key.  It returns zero if the attempt can go ahead, or how long key now has to wait if it can't.
+	Attempt(key string) (time.Duration, error)
+	// Release takes back an attempt of key that turned out not to be a failure, keeping its earlier failures.
+	Release(key string) error
+	// Reset forgets the failures of key.
+	Reset(key string) error
+}
+
+// LoginLimits limits logins both per account and per client, so that neither guessing many passwords for one account nor one password for many accounts gets far.
+type LoginLimits struct {
+	Accounts LoginLimiter
+	IPs      LoginLimiter
+}
+
+// Attempt records a login for email from ip, returning how long it has to wait if it can't go ahead.  Email addresses are limited whether or not they have an account, so the wait doesn't give away which ones do.  Attempts stay counted as failures unless Release or Succeed takes them back.
+func (l *LoginLimits) Attempt(email, ip string) (time.Duration, error) {
+	account, err := l.Accounts.Attempt(accountKey(email))
+	if err != nil {
+		return 0, err
+	}
+	client, err := l.IPs.Attempt(ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if client > account {
+		return client, nil
+	}
+	return account, nil
+}
+
+// Release takes back an attempt of email from ip that got through a step of logging in, such as the password of a user that still has to enter a code.
+func (l *LoginLimits) Release(email, ip string) error {
+	if err := l.Accounts.Release(accountKey(email)); err != nil {
+		return err
+	}
+	return l.IPs.Release(ipKey(ip))
+}
+
+// Succeed forgets the failures of an account once its owner logs in.  Only the attempt of the client is taken back, so that logging in to an account of their own doesn't let someone keep guessing at others.
+func (l *LoginLimits) Succeed(email, ip string) error {
+	if err := l.Accounts.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return l.IPs.Release(ipKey(ip))
+}
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// #region MEMORY
+
+// memoryPruneInterval is how often forgotten keys are cleared out of a memoryLimiter.
+const memoryPruneInterval = time.Minute
+
+type attempts struct {
+	failures int
+	last     time.Time
+}
+
+type memoryLimiter struct {
+	policy LimitPolicy
+
+	mu        sync.Mutex
+	keys      map[string]*attempts
+	lastPrune time.Time
+}
+
+// NewMemoryLimiter is the constructor of a LoginLimiter that keeps failures in memory.  It is fast and needs no database, but failures are lost on restart and aren't shared between servers.
+func NewMemoryLimiter(policy LimitPolicy) LoginLimiter {
+	return &memoryLimiter{
+		policy: policy,
+		keys:   make(map[string]*attempts),
+	}
+}
+
+// Attempt checks key against its earlier failures and counts the attempt, all under the lock.  An attempt that is refused still counts, so trying again too soon makes the wait longer.
+func (ml *memoryLimiter) Attempt(key string) (time.Duration, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	ml.prune(now)
+	a, ok := ml.keys[key]
+	if !ok || now.Sub(a.last) > ml.policy.Forget {
+		a = &attempts{}
+		ml.keys[key] = a
+	}
+	wait := ml.policy.wait(a.failures, a.last, now)
+	a.failures++
+	a.last = now
+	if wait > 0 {
+		return ml.policy.wait(a.failures, now, now), nil
+	}
+	return 0, nil
+}
+
+// Release takes back one attempt of key.
+func (ml *memoryLimiter) Release(key string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if a, ok := ml.keys[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+// Reset forgets the failures of key.
+func (ml *memoryLimiter) Reset(key string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	delete(ml.keys, key)
+	return nil
+}
+
+// prune removes keys whose failures have been forgotten, so the map doesn't grow forever.  It must be called with the lock held.
+func (ml *memoryLimiter) prune(now time.Time) {
+	if now.Sub(ml.lastPrune) < memoryPruneInterval {
+		return
+	}
+	ml.lastPrune = now
+	for key, a := range ml.keys {
+		if now.Sub(a.last) > ml.policy.Forget {
+			delete(ml.keys, key)
+		}
+	}
+}
+
+// #endregion
+
+// #region DATABASE
+
+// loginAttemptTTL is how long the row of a key is kept after its last failure.  Limiters with different policies share the table, so it is longer than any of them remember failures.
+const loginAttemptTTL = 24 * time.Hour
+
+// loginAttempt holds the failures of a key in the database.  PrevFailure is the failure before the last one, which is what an attempt is checked against, since the attempt itself becomes the last one.
+type loginAttempt struct {
+	Key         string `gorm:"primary_key"`
+	Failures    int    `gorm:"not null"`
+	LastFailure time.Time
+	PrevFailure *time.Time
+}
+
+type dbLimiter struct {
+	db     *gorm.DB
+	policy LimitPolicy
+}
+
+// NewDBLimiter is the constructor of a LoginLimiter that keeps failures in the database, so they survive restarts and are shared between servers.
+func NewDBLimiter(db *gorm.DB, policy LimitPolicy) LoginLimiter {
+	return &dbLimiter{db: db, policy: policy}
+}
+
+// Attempt counts an attempt of key and checks it against the failure before it in a single statement, so that attempts at the same time each see the ones before them.  Failures that were forgotten start over, and rows past loginAttemptTTL are cleaned up.
+func (dl *dbLimiter) Attempt(key string) (time.Duration, error) {
+	now := time.Now()
+	err := dl.db.Where("last_failure < ?", now.Add(-loginAttemptTTL)).Delete(&loginAttempt{}).Error
+	if err != nil {
+		return 0, err
+	}
+	var failures int
+	var prev *time.Time
+	err = dl.db.Raw(`
+		INSERT INTO login_attempts (key, failures, last_failure) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			prev_failure = login_attempts.last_failure,
+			last_failure = EXCLUDED.last_failure
+		RETURNING failures, prev_failure`,
+		key, now, now.Add(-dl.policy.Forget)).Row().Scan(&failures, &prev)
+	if err != nil {
+		return 0, err
+	}
+	var last time.Time
+	if prev != nil {
+		last = *prev
+	}
+	if dl.policy.wait(failures-1, last, now) > 0 {
+		return dl.policy.wait(failures, now, now), nil
+	}
+	return 0, nil
+}
+
+// Release takes back one attempt of key.
+func (dl *dbLimiter) Release(key string) error {
+	return dl.db.Exec(`UPDATE login_attempts SET failures = failures - 1 WHERE key = ? AND failures > 0`, key).Error
+}
+
+// Reset forgets the failures of key.
+func (dl *dbLimiter) Reset(key string) error {
+	return dl.db.Where("key = ?", key).Delete(&loginAttempt{}).Error
+}
+
+// #endregion
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = LimitPolicy{Free: 3, Delay: time.Second, Lockout: 15 * time.Minute, Forget: time.Hour}
+
+func TestLimitPolicyWait(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		failures int
+		last     time.Time
+		want     time.Duration
+	}{
+		{"no failures", 0, time.Time{}, 0},
+		{"free failures", 3, now, 0},
+		{"first delay", 4, now, time.Second},
+		{"delay doubles", 6, now, 4 * time.Second},
+		{"delay partly waited", 6, now.Add(-3 * time.Second), time.Second},
+		{"delay waited out", 6, now.Add(-5 * time.Second), 0},
+		{"last delay before lockout", 13, now, 512 * time.Second},
+		{"lockout", 14, now, 15 * time.Minute},
+		{"lockout does not overflow", 200, now, 15 * time.Minute},
+		{"forgotten", 200, now.Add(-time.Hour - time.Second), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testPolicy.wait(tt.failures, tt.last, now); got != tt.want {
+				t.Errorf("wait(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterAttempt(t *testing.T) {
+	ml := NewMemoryLimiter(testPolicy)
+	for i := 1; i <= testPolicy.Free+1; i++ {
+		wait, err := ml.Attempt("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Fatalf("attempt %d waited %s, want 0", i, wait)
+		}
+	}
+	wait, err := ml.Attempt("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The refused attempt counts too, so the wait has already doubled.
+	if wait != 2*time.Second {
+		t.Errorf("refused attempt waited %s, want 2s", wait)
+	}
+	if wait, _ := ml.Attempt("other"); wait != 0 {
+		t.Errorf("other key waited %s, want 0", wait)
+	}
+}
+
+func TestMemoryLimiterReleaseAndReset(t *testing.T) {
+	ml := NewMemoryLimiter(testPolicy)
+	// Attempts that are released never add up to a wait.
+	for i := 0; i < 10; i++ {
+		if wait, _ := ml.Attempt("key"); wait != 0 {
+			t.Fatalf("released attempt %d waited %s, want 0", i, wait)
+		}
+		if err := ml.Release("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= testPolicy.Free+1; i++ {
+		ml.Attempt("key")
+	}
+	if err := ml.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := ml.Attempt("key"); wait != 0 {
+		t.Errorf("attempt after reset waited %s, want 0", wait)
+	}
+}
+
+func TestMemoryLimiterForget(t *testing.T) {
+	ml := NewMemoryLimiter(testPolicy).(*memoryLimiter)
+	for i := 0; i <= testPolicy.Free+1; i++ {
+		ml.Attempt("key")
+	}
+	ml.keys["key"].last = time.Now().Add(-testPolicy.Forget - time.Second)
+	if wait, _ := ml.Attempt("key"); wait != 0 {
+		t.Errorf("attempt after forget waited %s, want 0", wait)
+	}
+	if failures := ml.keys["key"].failures; failures != 1 {
+		t.Errorf("failures after forget = %d, want 1", failures)
+	}
+}
+
+func TestMemoryLimiterBurst(t *testing.T) {
+	ml := NewMemoryLimiter(testPolicy)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := ml.Attempt("key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if want := testPolicy.Free + 1; allowed != want {
+		t.Errorf("%d attempts got through a burst, want %d", allowed, want)
+	}
+}
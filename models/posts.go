@@ -2,9 +2,12 @@ package models
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -14,20 +17,60 @@ import (
   meta "github.com/yuin/goldmark-meta"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
   highlighting "github.com/yuin/goldmark-highlighting"
   chromahtml "github.com/alecthomas/chroma/formatters/html" // Needed for goldmark syntax highlighting
-
-	"github.com/gorilla/feeds"
 )
 
 // Post will hold all of the information needed for a blog post.
 type Post struct {
 	gorm.Model
-	Title    string                 `gorm:"not_null"`
-	URLPath  string                 `gorm:"not_null"`
-	FilePath string                 `gorm:"not_null"`
-	Body     string                 `gorm:"-"` // Not stored in database
-	MetaData map[string]interface{} `gorm:"-"`
+	Title       string `gorm:"not_null"`
+	URLPath     string `gorm:"not_null"`
+	FilePath    string `gorm:"not_null"`
+	// GUID identifies the post in feeds.  It is set when the post is created and never changes, even if the post moves to a new URL.
+	GUID        string `gorm:"index"`
+	Draft       bool   `gorm:"default:false"`
+	PublishAt   *time.Time
+	Series      string
+	SeriesSlug  string `gorm:"index"`
+	SeriesOrder int
+	Tags        []Tag                  `gorm:"many2many:post_tags;save_associations:false"` // Written by SetTags
+	Body        string                 `gorm:"-"`                                           // Not stored in database
+	MetaData    map[string]interface{} `gorm:"-"`
+	TOC         []TOCEntry             `gorm:"-"`
+	// WordCount, ReadingTime (in minutes) and Excerpt are computed by ParseMD.
+	WordCount   int    `gorm:"-"`
+	ReadingTime int    `gorm:"-"`
+	Excerpt     string `gorm:"-"`
+	// ModTime is the modification time of the markdown file, set by ParseMD.
+	ModTime time.Time `gorm:"-"`
+}
+
+// IsPublished returns true if the post is visible to the public: it isn't a draft and it isn't scheduled for the future.
+func (p Post) IsPublished() bool {
+	if p.Draft {
+		return false
+	}
+	return p.PublishAt == nil || !p.PublishAt.After(time.Now())
+}
+
+// Status describes whether a post is a draft, scheduled or published.
+func (p Post) Status() string {
+	switch {
+	case p.Draft:
+		return "Draft"
+	case !p.IsPublished():
+		return "Scheduled for " + p.PublishAt.Format("January 2, 2006 at 3:04 PM")
+	default:
+		return "Published"
+	}
+}
+
+// SetSeries puts the post in the named series, or takes it out of its series if the name is empty.
+func (p *Post) SetSeries(name string) {
+	p.Series = strings.TrimSpace(name)
+	p.SeriesSlug = Slugify(p.Series)
 }
 
 // MetaData is constructed from YAML at the head of markdown files
@@ -42,13 +85,25 @@ type MetaData struct {
 type PostsService interface {
 	PostsDB
 	ParseMD(*Post) error
-  MakePostsFeed() error
   IsProduction() bool
+	Sync(dryRun bool) (*SyncReport, error)
+	StartScheduler(interval time.Duration) (stop func())
+	OnChange(fn func())
+	LastChanged() time.Time
+	CacheStats() CacheStats
+	Revisions(postID uint) ([]PostRevision, error)
+	Restore(post *Post, rev *PostRevision) error
 }
 
 type postsService struct {
   PostsDB
   IsProdVar bool
+	md        goldmark.Markdown
+	cache     *renderCache
+
+	listenersMu sync.Mutex
+	listeners   []func()
+	lastChanged time.Time
 }
 
 // NewPostsService is
@@ -60,6 +115,9 @@ func NewPostsService(db *gorm.DB, isProd bool) PostsService {
 			},
     },
     IsProdVar: isProd,
+		md:          newMarkdown(),
+		cache:       newRenderCache(),
+		lastChanged: time.Now(),
 	}
 }
 
@@ -68,93 +126,216 @@ func (ps *postsService) IsProduction() bool {
   return ps.IsProdVar
 }
 
-// ParseMD will parse the associated markdown of a post.  User Content, such as comments, should _never_ use this function, as it parses HTML as-is.
-func (ps *postsService) ParseMD(post *Post) error {
-	data, err := ioutil.ReadFile(post.FilePath)
-	if err != nil {
-		return err
+// OnChange registers a function to be called whenever posts are created, updated, deleted, synced or published on schedule.  Listeners are called synchronously, in the order they were registered.
+func (ps *postsService) OnChange(fn func()) {
+	ps.listenersMu.Lock()
+	defer ps.listenersMu.Unlock()
+	ps.listeners = append(ps.listeners, fn)
+}
+
+// LastChanged returns when listeners were last notified of a change, or when the service was created if nothing has changed since.  Nothing built from posts can be older than this.
+func (ps *postsService) LastChanged() time.Time {
+	ps.listenersMu.Lock()
+	defer ps.listenersMu.Unlock()
+	return ps.lastChanged
+}
+
+// changed calls every listener registered with OnChange.
+func (ps *postsService) changed() {
+	ps.listenersMu.Lock()
+	ps.lastChanged = time.Now()
+	listeners := make([]func(), len(ps.listeners))
+	copy(listeners, ps.listeners)
+	ps.listenersMu.Unlock()
+	for _, fn := range listeners {
+		fn()
 	}
-	md := goldmark.New(
-		goldmark.WithExtensions(
-      meta.Meta,
-      highlighting.NewHighlighting(
-        highlighting.WithStyle("fruity"),
-        highlighting.WithFormatOptions(
-            chromahtml.WithLineNumbers(true),
-        ),
-     ),
-		),
-		goldmark.WithRendererOptions(
-			html.WithUnsafe(),
-		),
-	)
-	var buf bytes.Buffer
-	ctx := parser.NewContext()
-	if err := md.Convert([]byte(data), &buf, parser.WithContext(ctx)); err != nil {
+}
+
+// Create will add a post to the database, record its first revision, then notify listeners.
+func (ps *postsService) Create(post *Post) error {
+	if err := ps.PostsDB.Create(post); err != nil {
 		return err
 	}
-
-	post.Body = buf.String()
-	post.MetaData = meta.Get(ctx)
-
+	ps.snapshot(post)
+	ps.changed()
 	return nil
 }
 
-// MakePostsFeed will create static feed files in atom, rss, and json.
-func (ps *postsService) MakePostsFeed() error {
-	now := time.Now()
-	feed := &feeds.Feed{
-		Title:       "Nathan's Blog",
-		Link:        &feeds.Link{Href: "https://nathanielwheeler.com"},
-		Description: "A blog about code and whatever I feel like.",
-		Author:      &feeds.Author{Name: "Nathaniel Wheeler", Email: "nathan@mailftp.com"},
-		Created:     now,
+// Update will edit a post in the database, record a revision, then notify listeners.
+func (ps *postsService) Update(post *Post) error {
+	if err := ps.PostsDB.Update(post); err != nil {
+		return err
 	}
+	ps.cache.invalidate(post.FilePath)
+	ps.snapshot(post)
+	ps.changed()
+	return nil
+}
 
-	posts, err := ps.PostsDB.GetAll()
+// Delete will remove a post from default queries, then notify listeners.
+func (ps *postsService) Delete(id uint) error {
+	post, err := ps.PostsDB.ByID(id)
 	if err != nil {
 		return err
 	}
-	for _, post := range posts {
-		ps.ParseMD(&post)
-		feed.Items = append(feed.Items, &feeds.Item{
-			Title:       post.MetaData["Title"].(string),
-			Link:        &feeds.Link{Href: "https://nathanielwheeler.com/blog/" + post.URLPath},
-			Description: post.Body,
-			Created:     post.CreatedAt,
-		})
+	if err := ps.PostsDB.Delete(id); err != nil {
+		return err
 	}
+	ps.cache.invalidate(post.FilePath)
+	ps.changed()
+	return nil
+}
+
+// CacheStats reports the hits and misses of the render cache used by ParseMD.
+func (ps *postsService) CacheStats() CacheStats {
+	return ps.cache.stats()
+}
 
-	atom, err := feed.ToAtom()
+// ParseMD will parse the associated markdown of a post.  User Content, such as comments, should _never_ use this function, as it parses HTML as-is.
+/* Renders are cached until the file's modification time or size changes, so this usually costs a single stat. */
+func (ps *postsService) ParseMD(post *Post) error {
+	info, err := os.Stat(post.FilePath)
 	if err != nil {
-		log.Println(err)
+		ps.cache.invalidate(post.FilePath)
 		return err
 	}
-	rss, err := feed.ToRss()
-	if err != nil {
-		log.Println(err)
-		return err
+	r, ok := ps.cache.get(post.FilePath, info.ModTime(), info.Size())
+	if !ok {
+		r, err = ps.render(post.FilePath)
+		if err != nil {
+			return err
+		}
+		r.modTime, r.size = info.ModTime(), info.Size()
+		ps.cache.put(post.FilePath, r)
 	}
-	json, err := feed.ToJSON()
+
+	post.Body = r.body
+	post.MetaData = r.metaData
+	post.TOC = r.toc
+	post.WordCount = r.stats.wordCount
+	post.ReadingTime = r.stats.readingTime
+	post.Excerpt = r.stats.excerpt
+	post.ModTime = info.ModTime()
+
+	return nil
+}
+
+// render reads a markdown file and converts it to HTML.  Unless the front matter sets "TOC: false", headings get a self-link and are collected into a table of contents.
+func (ps *postsService) render(path string) (*rendered, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Println(err)
-		return err
+		return nil, err
+	}
+	ctx := parser.NewContext()
+	doc := ps.md.Parser().Parse(text.NewReader(data), parser.WithContext(ctx))
+	metaData := meta.Get(ctx)
+	// Stats are computed before heading anchors are added, so that the anchors aren't counted as words.
+	stats := computeReadingStats(ps.md.Parser(), doc, data)
+
+	var toc []TOCEntry
+	if tocEnabled(metaData) {
+		toc = buildTOC(doc, data)
+		addHeadingAnchors(doc)
 	}
 
-	feeds := map[string]string{
-		"atom": atom,
-		"rss":  rss,
-		"json": json,
+	var buf bytes.Buffer
+	if err := ps.md.Renderer().Render(&buf, data, doc); err != nil {
+		return nil, err
 	}
-	for k, feed := range feeds {
-		f, err := os.OpenFile("public/feeds/feed."+k, os.O_WRONLY, 0777)
-		if err != nil {
-			return err
+	return &rendered{
+		body:     buf.String(),
+		metaData: metaData,
+		toc:      toc,
+		stats:    stats,
+	}, nil
+}
+
+// newMarkdown builds the markdown converter shared by every render.
+func newMarkdown() goldmark.Markdown {
+	return goldmark.New(
+		goldmark.WithExtensions(
+			meta.Meta,
+			highlighting.NewHighlighting(
+				highlighting.WithStyle("fruity"),
+				highlighting.WithFormatOptions(
+					chromahtml.WithLineNumbers(true),
+				),
+			),
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithUnsafe(),
+		),
+	)
+}
+
+// #endregion
+
+// #region META HELPERS
+
+// metaDateLayouts are the date formats accepted in front matter.
+var metaDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"January 2, 2006",
+}
+
+// metaString gets a front matter value as a string, or an empty string if it is missing.
+func metaString(md map[string]interface{}, key string) string {
+	v, ok := md[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// metaBool gets a front matter value as a bool.  Strings such as "yes" and "true" count as true.
+func metaBool(md map[string]interface{}, key string) bool {
+	switch v := md[key].(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "on", "1":
+			return true
 		}
-		f.WriteString(feed)
 	}
+	return false
+}
 
-	return nil
+// metaInt gets a front matter value as an int, or zero if it isn't a number.
+func metaInt(md map[string]interface{}, key string) int {
+	switch v := md[key].(type) {
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(v))
+		return n
+	}
+	return 0
+}
+
+// metaTime gets a front matter value as a time, trying every layout in metaDateLayouts.
+func metaTime(md map[string]interface{}, key string) (time.Time, bool) {
+	switch v := md[key].(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range metaDateLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
 }
 
 // #endregion
@@ -167,8 +348,23 @@ func (ps *postsService) MakePostsFeed() error {
 type PostsDB interface {
 	ByID(id uint) (*Post, error)
 	ByURL(urlpath string) (*Post, error)
+	ByURLWithDrafts(urlpath string) (*Post, error)
 	ByLatest() (*Post, error)
 	GetAll() ([]Post, error)
+	GetPage(limit, offset int) ([]Post, error)
+	Count() (int, error)
+	ByYear(year int) ([]Post, error)
+	SitemapEntries() ([]SitemapEntry, error)
+	GetAllWithDrafts() ([]Post, error)
+	ScheduledBetween(from, to time.Time) ([]Post, error)
+	BySeries(slug string) ([]Post, error)
+	Adjacent(post *Post) (prev, next *Post, err error)
+	ByTag(slug string) ([]Post, error)
+	TagBySlug(slug string) (*Tag, error)
+	Tags() ([]TagCount, error)
+	SetTags(post *Post, tags []Tag) error
+	CreateRevision(rev *PostRevision) error
+	RevisionsByPost(postID uint) ([]PostRevision, error)
 	Create(post *Post) error
 	Update(post *Post) error
 	Delete(id uint) error
@@ -188,7 +384,7 @@ var _ PostsDB = &postsGorm{}
 // ByID will search the posts database for a post using input ID.
 func (pg *postsGorm) ByID(id uint) (*Post, error) {
 	var post Post
-	db := pg.db.Where("id = ?", id)
+	db := pg.withTags().Where("id = ?", id)
 	err := first(db, &post)
 	if err != nil {
 		return nil, err
@@ -196,10 +392,10 @@ func (pg *postsGorm) ByID(id uint) (*Post, error) {
 	return &post, nil
 }
 
-// ByURL will search the posts database for input url string.
+// ByURL will search the published posts for input url string.
 func (pg *postsGorm) ByURL(urlpath string) (*Post, error) {
 	var post Post
-	db := pg.db.Where("url_path = ?", urlpath)
+	db := published(pg.withTags()).Where("url_path = ?", urlpath)
 	err := first(db, &post)
 	if err != nil {
 		return nil, err
@@ -207,25 +403,203 @@ func (pg *postsGorm) ByURL(urlpath string) (*Post, error) {
 	return &post, nil
 }
 
-// ByLatest will get the most recent post (by CreatedAt)
+// ByURLWithDrafts will search every post, including drafts and scheduled posts, for input url string.  This is meant for admins.
+func (pg *postsGorm) ByURLWithDrafts(urlpath string) (*Post, error) {
+	var post Post
+	db := pg.withTags().Where("url_path = ?", urlpath)
+	err := first(db, &post)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// ByLatest will get the most recent published post (by CreatedAt)
 func (pg *postsGorm) ByLatest() (*Post, error) {
 	var post Post
-	pg.db.Raw(`SELECT * FROM "posts"  WHERE "posts"."deleted_at" IS NULL ORDER BY created_at DESC LIMIT 1`).Scan(&post)
+	db := published(pg.withTags()).Order("created_at DESC")
+	err := first(db, &post)
+	if err != nil {
+		return nil, err
+	}
 	return &post, nil
 }
 
-// GetAll will return all posts from newest to oldest.
+// GetAll will return all published posts from newest to oldest.
 func (pg *postsGorm) GetAll() ([]Post, error) {
 	var posts []Post
-	if err := pg.db.Order("created_at").Find(&posts).Error; err != nil {
+	if err := published(pg.withTags()).Order("created_at DESC").Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetPage will return up to limit published posts from newest to oldest, skipping the first offset posts.
+func (pg *postsGorm) GetPage(limit, offset int) ([]Post, error) {
+	var posts []Post
+	err := published(pg.withTags()).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// Count will return the number of published posts.
+func (pg *postsGorm) Count() (int, error) {
+	var count int
+	if err := published(pg.db.Model(&Post{})).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ByYear will return the published posts created in the given year, from newest to oldest.
+func (pg *postsGorm) ByYear(year int) ([]Post, error) {
+	var posts []Post
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err := published(pg.withTags()).
+		Where("created_at >= ? AND created_at < ?", from, from.AddDate(1, 0, 0)).
+		Order("created_at DESC").
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetAllWithDrafts will return every post, including drafts and scheduled posts, from newest to oldest.
+func (pg *postsGorm) GetAllWithDrafts() ([]Post, error) {
+	var posts []Post
+	if err := pg.withTags().Order("created_at DESC").Find(&posts).Error; err != nil {
 		return nil, err
 	}
-	for i, v := 0, len(posts)-1; i < v; i, v = i+1, v-1 {
-		posts[i], posts[v] = posts[v], posts[i]
+	return posts, nil
+}
+
+// ScheduledBetween will return the non-draft posts whose PublishAt falls after from, up to and including to.
+func (pg *postsGorm) ScheduledBetween(from, to time.Time) ([]Post, error) {
+	var posts []Post
+	err := pg.db.
+		Where("draft = ? AND publish_at > ? AND publish_at <= ?", false, from, to).
+		Order("publish_at").
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
 	}
 	return posts, nil
 }
 
+// BySeries will return the published posts of a series, in series order.  Posts without an order fall back on when they were created.
+func (pg *postsGorm) BySeries(slug string) ([]Post, error) {
+	var posts []Post
+	err := published(pg.withTags()).
+		Where("series_slug = ?", slug).
+		Order("series_order, created_at").
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// Adjacent will find the published posts created just before and just after the given post.  Either may be nil at the ends of the archive.
+func (pg *postsGorm) Adjacent(post *Post) (prev, next *Post, err error) {
+	var p, n Post
+	err = first(published(pg.db).Where("created_at < ?", post.CreatedAt).Order("created_at DESC"), &p)
+	switch err {
+	case nil:
+		prev = &p
+	case ErrNotFound:
+	default:
+		return nil, nil, err
+	}
+	err = first(published(pg.db).Where("created_at > ?", post.CreatedAt).Order("created_at"), &n)
+	switch err {
+	case nil:
+		next = &n
+	case ErrNotFound:
+	default:
+		return nil, nil, err
+	}
+	return prev, next, nil
+}
+
+// ByTag will return all posts with the given tag slug, from newest to oldest.
+func (pg *postsGorm) ByTag(slug string) ([]Post, error) {
+	var posts []Post
+	err := published(pg.withTags()).
+		Joins("JOIN post_tags ON post_tags.post_id = posts.id").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").
+		Where("tags.slug = ?", slug).
+		Order("posts.created_at DESC").
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// TagBySlug will search the tags table for the given slug.
+func (pg *postsGorm) TagBySlug(slug string) (*Tag, error) {
+	var tag Tag
+	err := first(pg.db.Where("slug = ?", slug), &tag)
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// Tags will return every tag in use, along with how many posts use it, sorted by name.
+func (pg *postsGorm) Tags() ([]TagCount, error) {
+	var tags []TagCount
+	err := pg.db.Table("tags").
+		Select("tags.name, tags.slug, COUNT(posts.id) AS count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL").
+		Where("posts.draft = ? AND (posts.publish_at IS NULL OR posts.publish_at <= ?)", false, time.Now()).
+		Group("tags.id, tags.name, tags.slug").
+		Order("tags.name").
+		Scan(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// SetTags will replace the tags of a post.  Tags that don't exist yet are created.
+func (pg *postsGorm) SetTags(post *Post, tags []Tag) error {
+	tx := pg.db.Begin()
+	for i := range tags {
+		err := tx.Where(Tag{Slug: tags[i].Slug}).
+			Attrs(Tag{Name: tags[i].Name}).
+			FirstOrCreate(&tags[i]).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Exec("DELETE FROM post_tags WHERE post_id = ?", post.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, tag := range tags {
+		err := tx.Exec("INSERT INTO post_tags (post_id, tag_id) VALUES (?, ?)", post.ID, tag.ID).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	post.Tags = tags
+	return nil
+}
+
 // Create will add a post to the database
 func (pg *postsGorm) Create(post *Post) error {
 	return pg.db.Create(post).Error
@@ -245,6 +619,22 @@ func (pg *postsGorm) Delete(id uint) error {
 
 //    #endregion
 
+//    #region GORM HELPERS
+
+// published limits a query to posts that the public may see: not drafts, and not scheduled for the future.
+func published(db *gorm.DB) *gorm.DB {
+	return db.Where("posts.draft = ? AND (posts.publish_at IS NULL OR posts.publish_at <= ?)", false, time.Now())
+}
+
+// withTags preloads the tags of every post found, sorted by slug.
+func (pg *postsGorm) withTags() *gorm.DB {
+	return pg.db.Preload("Tags", func(db *gorm.DB) *gorm.DB {
+		return db.Order("tags.slug")
+	})
+}
+
+//    #endregion
+
 // #endregion
 
 // #region VALIDATOR
@@ -263,7 +653,8 @@ Ensure that title doesn't have any underscores in it
 
 func (pv *postsValidator) Create(post *Post) error {
 	err := runPostsValFns(post,
-		pv.titleRequired)
+		pv.titleRequired,
+		pv.setGUIDIfUnset)
 	if err != nil {
 		return err
 	}
@@ -272,7 +663,8 @@ func (pv *postsValidator) Create(post *Post) error {
 
 func (pv *postsValidator) Update(post *Post) error {
 	err := runPostsValFns(post,
-		pv.titleRequired)
+		pv.titleRequired,
+		pv.setGUIDIfUnset)
 	if err != nil {
 		return err
 	}
@@ -310,6 +702,19 @@ func (pv *postsValidator) titleRequired(p *Post) error {
 	return nil
 }
 
+// setGUIDIfUnset gives a post without a GUID a new one.  Posts that already have one keep it.
+func (pv *postsValidator) setGUIDIfUnset(post *Post) error {
+	if post.GUID != "" {
+		return nil
+	}
+	guid, err := newGUID()
+	if err != nil {
+		return err
+	}
+	post.GUID = guid
+	return nil
+}
+
 func (pv *postsValidator) nonZeroID(post *Post) error {
 	if post.ID <= 0 {
 		return errIDInvalid
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"time"
+
+	"nathanielwheeler.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// resetTokenLifetime is how long a reset link works after it is sent.
+	resetTokenLifetime = time.Hour
+	// resetResendInterval is how long a user has to wait between password reset emails, so that the form can't be used to flood their inbox.
+	resetResendInterval = 5 * time.Minute
+)
+
+// pwReset is a password reset that was asked for.  Only the HMAC hash of the token is stored, so a leaked database can't be used to reset passwords.
+type pwReset struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
+
+// pwResetDB is used to interact with the password resets database.
+type pwResetDB interface {
+	ByToken(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	DeleteByUser(userID uint) error
+}
+
+// #region SERVICE
+
+// InitiateReset creates a reset token for the user with the given email and returns it along with the user, so it can be mailed to them.  Any earlier tokens of the user stop working.  It returns ErrResetTooSoon if one was sent recently.
+func (us *userService) InitiateReset(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	if user.ResetSentAt != nil && now.Sub(*user.ResetSentAt) < resetResendInterval {
+		return nil, "", ErrResetTooSoon
+	}
+	user.ResetSentAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, "", err
+	}
+	if err := us.pwResetDB.DeleteByUser(user.ID); err != nil {
+		return nil, "", err
+	}
+	pwr := pwReset{UserID: user.ID}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+// CompleteReset sets a new password for the user that token was made for.  The password goes through the same validation as any other update.  A token can only be used once.
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	pwr, err := us.pwResetDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if time.Since(pwr.CreatedAt) > resetTokenLifetime {
+		return nil, ErrTokenInvalid
+	}
+	// Update would keep the old password if it were left empty.
+	if newPw == "" {
+		return nil, errPasswordRequired
+	}
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = newPw
+	// Following the link proves that they own the email address, too.
+	if !user.Verified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	if err := us.pwResetDB.DeleteByUser(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// #endregion
+
+// #region GORM
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+// ByToken gets a password reset given the hash of its token.
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr)
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
+// Create takes in a validated password reset and adds it to the database.
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+// DeleteByUser removes every password reset of a user.  They are deleted for good, since a used token should never be found again.
+func (pwrg *pwResetGorm) DeleteByUser(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
+
+// #endregion
+
+// #region VALIDATION
+
+// pwResetValidator hashes tokens.  It keeps the key rather than an HMAC, since resets can be asked for by many requests at once.
+type pwResetValidator struct {
+	pwResetDB
+	hmacKey string
+}
+
+func newPwResetValidator(db pwResetDB, hmacKey string) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmacKey:   hmacKey,
+	}
+}
+
+// ByToken will hash the token before looking it up.
+func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
+	pwr := pwReset{Token: token}
+	if err := runPwResetValFns(&pwr, pwrv.hmacToken); err != nil {
+		return nil, err
+	}
+	return pwrv.pwResetDB.ByToken(pwr.TokenHash)
+}
+
+// Create will make a token for the reset and hash it before it is saved.
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFns(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+// DeleteByUser makes sure that a user ID of zero never reaches the database.
+func (pwrv *pwResetValidator) DeleteByUser(userID uint) error {
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	return pwrv.pwResetDB.DeleteByUser(userID)
+}
+
+type pwResetValFn func(*pwReset) error
+
+// runPwResetValFns runs each validation function against a password reset, stopping at the first error.
+func runPwResetValFns(pwr *pwReset, fns ...pwResetValFn) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireUserID makes sure that the reset belongs to someone.
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return errUserIDRequired
+	}
+	return nil
+}
+
+// setTokenIfUnset gives the reset a random token of rand.TokenBytes bytes.
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.Token()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+// hmacToken hashes the token of the reset, if there is one.
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return nil
+	}
+	pwr.TokenHash = hmacWith(pwrv.hmacKey, pwr.Token)
+	return nil
+}
+
+// #endregion
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"nathanielwheeler.com/hash"
+)
+
+// memoryUserDB is a UserDB that keeps users in a map, so the user service can be tested without Postgres.
+type memoryUserDB struct {
+	users  map[uint]User
+	nextID uint
+}
+
+func (db *memoryUserDB) ByID(id uint) (*User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (db *memoryUserDB) ByEmail(email string) (*User, error) {
+	for _, user := range db.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (db *memoryUserDB) Create(user *User) error {
+	db.nextID++
+	user.ID = db.nextID
+	user.CreatedAt = time.Now()
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *memoryUserDB) Update(user *User) error {
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *memoryUserDB) Delete(id uint) error {
+	delete(db.users, id)
+	return nil
+}
+
+// memoryPwResetDB is a pwResetDB that keeps resets in a slice.
+type memoryPwResetDB struct {
+	resets []pwReset
+}
+
+func (db *memoryPwResetDB) ByToken(tokenHash string) (*pwReset, error) {
+	for _, pwr := range db.resets {
+		if pwr.TokenHash == tokenHash {
+			return &pwr, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (db *memoryPwResetDB) Create(pwr *pwReset) error {
+	pwr.CreatedAt = time.Now()
+	db.resets = append(db.resets, *pwr)
+	return nil
+}
+
+func (db *memoryPwResetDB) DeleteByUser(userID uint) error {
+	kept := db.resets[:0]
+	for _, pwr := range db.resets {
+		if pwr.UserID != userID {
+			kept = append(kept, pwr)
+		}
+	}
+	db.resets = kept
+	return nil
+}
+
+// newTestUserService builds a user service on top of the real validators and in-memory databases, with one user already signed up.
+func newTestUserService(t *testing.T) (*userService, *memoryPwResetDB, *User) {
+	const key = "test hmac key"
+	pwrdb := &memoryPwResetDB{}
+	us := &userService{
+		UserDB:    newUserValidator(&memoryUserDB{users: make(map[uint]User)}, "pepper"),
+		pwResetDB: newPwResetValidator(pwrdb, key),
+		pepper:    "pepper",
+		hmacKey:   key,
+	}
+	user := User{Name: "Reader", Email: "reader@example.com", Password: "old password"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return us, pwrdb, &user
+}
+
+func TestResetTokenWorksOnce(t *testing.T) {
+	us, _, user := newTestUserService(t)
+	_, token, err := us.InitiateReset(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.CompleteReset(token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "new password"); err != nil {
+		t.Errorf("new password does not work: %v", err)
+	}
+	if _, err := us.CompleteReset(token, "another password"); err != ErrTokenInvalid {
+		t.Errorf("second use of a token returned %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.Authenticate(user.Email, "new password"); err != nil {
+		t.Errorf("second use of a token changed the password: %v", err)
+	}
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	us, pwrdb, user := newTestUserService(t)
+	_, token, err := us.InitiateReset(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwrdb.resets[0].CreatedAt = time.Now().Add(-resetTokenLifetime - time.Minute)
+	if _, err := us.CompleteReset(token, "new password"); err != ErrTokenInvalid {
+		t.Errorf("expired token returned %v, want ErrTokenInvalid", err)
+	}
+	if _, err := us.Authenticate(user.Email, "old password"); err != nil {
+		t.Errorf("expired token changed the password: %v", err)
+	}
+}
+
+func TestResetTooSoon(t *testing.T) {
+	us, _, user := newTestUserService(t)
+	if _, _, err := us.InitiateReset(user.Email); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := us.InitiateReset(user.Email); err != ErrResetTooSoon {
+		t.Errorf("second reset returned %v, want ErrResetTooSoon", err)
+	}
+	sent := time.Now().Add(-resetResendInterval - time.Second)
+	user, _ = us.ByID(user.ID)
+	user.ResetSentAt = &sent
+	if err := us.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := us.InitiateReset(user.Email); err != nil {
+		t.Errorf("reset after the interval returned %v", err)
+	}
+}
+
+func TestResetTokenHashParallel(t *testing.T) {
+	const key = "test hmac key"
+	pwrv := newPwResetValidator(&memoryPwResetDB{}, key)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := fmt.Sprintf("token %d", i)
+			for j := 0; j < 20; j++ {
+				pwr := pwReset{Token: token}
+				if err := pwrv.hmacToken(&pwr); err != nil {
+					t.Error(err)
+					return
+				}
+				if want := hash.NewHMAC(key).Hash(token); pwr.TokenHash != want {
+					t.Errorf("hash of %q = %q, want %q", token, pwr.TokenHash, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
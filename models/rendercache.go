@@ -0,0 +1,84 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats reports how well the render cache is doing.
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// HitRate is the percentage of lookups that were served from the cache.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(s.Hits) / float64(total)
+}
+
+// rendered is the output of rendering a markdown file.
+type rendered struct {
+	modTime  time.Time
+	size     int64
+	body     string
+	metaData map[string]interface{}
+	toc      []TOCEntry
+	stats    readingStats
+}
+
+// renderCache holds rendered markdown keyed by file path.  An entry is only valid while the file keeps the modification time and size it had when it was rendered.  It is safe for concurrent use.
+type renderCache struct {
+	mu      sync.RWMutex
+	entries map[string]*rendered
+	hits    uint64
+	misses  uint64
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{
+		entries: make(map[string]*rendered),
+	}
+}
+
+// get returns the cached render of path if the file hasn't changed since it was stored.
+func (rc *renderCache) get(path string, modTime time.Time, size int64) (*rendered, bool) {
+	rc.mu.RLock()
+	r, ok := rc.entries[path]
+	rc.mu.RUnlock()
+	if !ok || !r.modTime.Equal(modTime) || r.size != size {
+		atomic.AddUint64(&rc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&rc.hits, 1)
+	return r, true
+}
+
+func (rc *renderCache) put(path string, r *rendered) {
+	rc.mu.Lock()
+	rc.entries[path] = r
+	rc.mu.Unlock()
+}
+
+// invalidate drops the entry for path, so that the next lookup renders it again.
+func (rc *renderCache) invalidate(path string) {
+	rc.mu.Lock()
+	delete(rc.entries, path)
+	rc.mu.Unlock()
+}
+
+func (rc *renderCache) stats() CacheStats {
+	rc.mu.RLock()
+	entries := len(rc.entries)
+	rc.mu.RUnlock()
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&rc.hits),
+		Misses:  atomic.LoadUint64(&rc.misses),
+		Entries: entries,
+	}
+}
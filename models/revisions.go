@@ -0,0 +1,83 @@
+package models
+
+import (
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// PostRevision is a snapshot of a post and its markdown, taken whenever the post is created, updated or synced.
+type PostRevision struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PostID    uint   `gorm:"not null;index"`
+	Title     string `gorm:"not null"`
+	URLPath   string `gorm:"not null"`
+	Content   string `gorm:"type:text"`
+}
+
+// Revisions will return every revision of a post, newest first.
+func (ps *postsService) Revisions(postID uint) ([]PostRevision, error) {
+	return ps.PostsDB.RevisionsByPost(postID)
+}
+
+// Restore rolls a post back to a revision.  The markdown file is overwritten with the revision's content, and the restore itself is recorded as a new revision.
+func (ps *postsService) Restore(post *Post, rev *PostRevision) error {
+	if rev.PostID != post.ID {
+		return ErrNotFound
+	}
+	if err := ioutil.WriteFile(post.FilePath, []byte(rev.Content), 0644); err != nil {
+		return err
+	}
+	post.Title = rev.Title
+	post.URLPath = rev.URLPath
+	return ps.Update(post)
+}
+
+// snapshot records the current state of a post as a revision, unless nothing changed since the latest one.  Failing to take a snapshot is logged rather than returned, since the post itself was saved.
+func (ps *postsService) snapshot(post *Post) {
+	content, err := ioutil.ReadFile(post.FilePath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	rev := PostRevision{
+		PostID:  post.ID,
+		Title:   post.Title,
+		URLPath: post.URLPath,
+		Content: string(content),
+	}
+	revs, err := ps.PostsDB.RevisionsByPost(post.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(revs) > 0 {
+		latest := revs[0]
+		if latest.Title == rev.Title && latest.URLPath == rev.URLPath && latest.Content == rev.Content {
+			return
+		}
+	}
+	if err := ps.PostsDB.CreateRevision(&rev); err != nil {
+		log.Println(err)
+	}
+}
+
+// #region GORM
+
+// CreateRevision will add a revision to the database.
+func (pg *postsGorm) CreateRevision(rev *PostRevision) error {
+	return pg.db.Create(rev).Error
+}
+
+// RevisionsByPost will return the revisions of a post, newest first.
+func (pg *postsGorm) RevisionsByPost(postID uint) ([]PostRevision, error) {
+	var revs []PostRevision
+	err := pg.db.Where("post_id = ?", postID).Order("created_at DESC, id DESC").Find(&revs).Error
+	if err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// #endregion
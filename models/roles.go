@@ -0,0 +1,128 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// Role is what a user is allowed to do on the site.  Each role can do everything the role before it can, and more.
+type Role string
+
+const (
+	// RoleReader can sign in and get updates.  New users start here.
+	RoleReader Role = "reader"
+	// RoleCommenter can also comment on posts.
+	RoleCommenter Role = "commenter"
+	// RoleAuthor can also write and edit posts, and upload images for them.
+	RoleAuthor Role = "author"
+	// RoleEditor can also delete posts and images, and sync posts with the markdown on disk.
+	RoleEditor Role = "editor"
+	// RoleAdmin can do everything.
+	RoleAdmin Role = "admin"
+)
+
+// Permission is a single thing a role can do, named like "posts:create".
+type Permission string
+
+const (
+	// PermCommentsCreate lets a user comment on posts.
+	PermCommentsCreate Permission = "comments:create"
+	// PermPostsCreate lets a user write new posts.
+	PermPostsCreate Permission = "posts:create"
+	// PermPostsEdit lets a user change posts that already exist.
+	PermPostsEdit Permission = "posts:edit"
+	// PermPostsDrafts lets a user open drafts and scheduled posts before they are published.
+	PermPostsDrafts Permission = "posts:drafts"
+	// PermPostsDelete lets a user delete posts.
+	PermPostsDelete Permission = "posts:delete"
+	// PermPostsSync lets a user sync the posts table with the markdown on disk.
+	PermPostsSync Permission = "posts:sync"
+	// PermImagesUpload lets a user upload images for posts.
+	PermImagesUpload Permission = "images:upload"
+	// PermImagesDelete lets a user delete images.
+	PermImagesDelete Permission = "images:delete"
+)
+
+// roleGrant is what a role can do on top of the role before it.
+type roleGrant struct {
+	role  Role
+	perms []Permission
+}
+
+// roleLadder lists the roles from least to most trusted.
+var roleLadder = []roleGrant{
+	{RoleReader, nil},
+	{RoleCommenter, []Permission{PermCommentsCreate}},
+	{RoleAuthor, []Permission{PermPostsCreate, PermPostsEdit, PermPostsDrafts, PermImagesUpload}},
+	{RoleEditor, []Permission{PermPostsDelete, PermPostsSync, PermImagesDelete}},
+	{RoleAdmin, nil},
+}
+
+// rolePermissions is every permission of each role, including those it gets from the roles before it.
+var rolePermissions = func() map[Role]map[Permission]bool {
+	roles := make(map[Role]map[Permission]bool, len(roleLadder))
+	granted := make(map[Permission]bool)
+	for _, grant := range roleLadder {
+		perms := make(map[Permission]bool, len(granted)+len(grant.perms))
+		for perm := range granted {
+			perms[perm] = true
+		}
+		for _, perm := range grant.perms {
+			perms[perm] = true
+			granted[perm] = true
+		}
+		roles[grant.role] = perms
+	}
+	return roles
+}()
+
+// verifiedPermissions need a verified email address on top of the role, since they let a user post where others can read it or have mail sent to them.
+var verifiedPermissions = map[Permission]bool{
+	PermCommentsCreate: true,
+}
+
+// NeedsVerified returns true if a user has to verify their email address before they can use p.
+func (p Permission) NeedsVerified() bool {
+	return verifiedPermissions[p]
+}
+
+// Valid returns true if r is one of the roles above.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// privilegedPermissions change what everyone reads on the site.  Roles with any of them need two-factor authentication.  New permissions have to be added here on purpose.
+var privilegedPermissions = map[Permission]bool{
+	PermPostsCreate:  true,
+	PermPostsEdit:    true,
+	PermPostsDrafts:  true,
+	PermPostsDelete:  true,
+	PermPostsSync:    true,
+	PermImagesUpload: true,
+	PermImagesDelete: true,
+}
+
+// Privileged returns true if r has any of the privileged permissions, which is what two-factor authentication is there to protect.
+func (r Role) Privileged() bool {
+	for perm := range rolePermissions[r] {
+		if privilegedPermissions[perm] {
+			return true
+		}
+	}
+	return false
+}
+
+// Can returns true if the role of the user grants perm.
+func (u *User) Can(perm Permission) bool {
+	return rolePermissions[u.Role][perm]
+}
+
+// backfillRoles moves users from the old is_admin column onto roles, then drops the column, so it only runs once.  Everyone else keeps the default role.
+func backfillRoles(db *gorm.DB) error {
+	if !db.Dialect().HasColumn("users", "is_admin") {
+		return nil
+	}
+	err := db.Unscoped().Model(&User{}).Where("is_admin = ?", true).UpdateColumn("role", RoleAdmin).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&User{}).DropColumn("is_admin").Error
+}
@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestRolePrivileged(t *testing.T) {
+	tests := []struct {
+		role Role
+		want bool
+	}{
+		{RoleReader, false},
+		{RoleCommenter, false},
+		{RoleAuthor, true},
+		{RoleEditor, true},
+		{RoleAdmin, true},
+		{Role("unknown"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Privileged(); got != tt.want {
+			t.Errorf("%s.Privileged() = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// StartScheduler checks for scheduled posts every interval.  Public queries already hide posts until their PublishAt passes, so all that is left to do when a post goes live is log it and notify listeners, such as the feeds.  Calling the returned function stops the scheduler.
+func (ps *postsService) StartScheduler(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				ps.publishScheduled(last, now)
+				last = now
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// publishScheduled handles every post that went live after from, up to and including to.
+func (ps *postsService) publishScheduled(from, to time.Time) {
+	posts, err := ps.PostsDB.ScheduledBetween(from, to)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(posts) == 0 {
+		return
+	}
+	for _, post := range posts {
+		log.Printf("Published scheduled post %q", post.Title)
+	}
+	ps.changed()
+}
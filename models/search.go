@@ -0,0 +1,304 @@
+package models
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// titleBoost is how much more a term in the title counts than a term in the body.
+	titleBoost = 3.0
+	// snippetWords is the number of words shown around the first match in a snippet.
+	snippetWords = 30
+)
+
+// SearchResult is a single post that matched a search, with a highlighted snippet of its text.
+type SearchResult struct {
+	Post    Post
+	Score   float64
+	Snippet []SnippetPart
+}
+
+// SnippetPart is a piece of a snippet.  Parts with Match set should be highlighted.
+type SnippetPart struct {
+	Text  string
+	Match bool
+}
+
+// SearchSource is what the search index is built from.  PostsService satisfies it, and tests can use a fake without a database.
+type SearchSource interface {
+	GetAll() ([]Post, error)
+	ParseMD(*Post) error
+}
+
+// SearchService ranks posts against a query using an in-process inverted index of their titles and rendered text.
+type SearchService interface {
+	Search(query string, limit int) ([]SearchResult, error)
+	// Rebuild throws away the index and indexes every published post again.
+	Rebuild() error
+}
+
+type searchService struct {
+	src SearchSource
+
+	mu    sync.RWMutex
+	index *searchIndex
+}
+
+// NewSearchService is the constructor of SearchService.  The index is built on the first search.
+func NewSearchService(src SearchSource) SearchService {
+	return &searchService{src: src}
+}
+
+// Search returns up to limit posts that match any term of the query, best match first.  A limit of zero or less returns every match.
+func (ss *searchService) Search(query string, limit int) ([]SearchResult, error) {
+	terms := uniqueTerms(tokenize(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	ss.mu.RLock()
+	index := ss.index
+	ss.mu.RUnlock()
+	if index == nil {
+		if err := ss.Rebuild(); err != nil {
+			return nil, err
+		}
+		ss.mu.RLock()
+		index = ss.index
+		ss.mu.RUnlock()
+	}
+
+	results := index.search(terms)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Rebuild indexes every published post.  The new index is swapped in once it is complete, so searches are never run against a half built index.
+func (ss *searchService) Rebuild() error {
+	posts, err := ss.src.GetAll()
+	if err != nil {
+		return err
+	}
+	index := newSearchIndex()
+	for _, post := range posts {
+		if err := ss.src.ParseMD(&post); err != nil {
+			// A post with a missing file still has a title worth finding.
+			post.Body = ""
+		}
+		index.add(post)
+	}
+
+	ss.mu.Lock()
+	ss.index = index
+	ss.mu.Unlock()
+	return nil
+}
+
+// #region INDEX
+
+// searchDoc is a post along with the plain text that was indexed for it.
+type searchDoc struct {
+	post   Post
+	text   string
+	length int
+}
+
+// posting counts how often a term shows up in one document.
+type posting struct {
+	body  int
+	title int
+}
+
+type searchIndex struct {
+	docs  []searchDoc
+	terms map[string]map[int]*posting
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		terms: make(map[string]map[int]*posting),
+	}
+}
+
+// add indexes the title and the rendered body of a post.
+func (si *searchIndex) add(post Post) {
+	title := post.Title
+	if t := metaString(post.MetaData, "Title"); t != "" {
+		title = t
+	}
+	text := plainText(post.Body)
+	titleTerms := tokenize(title)
+	bodyTerms := tokenize(text)
+
+	id := len(si.docs)
+	si.docs = append(si.docs, searchDoc{
+		post:   post,
+		text:   text,
+		length: len(titleTerms) + len(bodyTerms),
+	})
+	for _, term := range titleTerms {
+		si.posting(term, id).title++
+	}
+	for _, term := range bodyTerms {
+		si.posting(term, id).body++
+	}
+}
+
+func (si *searchIndex) posting(term string, id int) *posting {
+	docs, ok := si.terms[term]
+	if !ok {
+		docs = make(map[int]*posting)
+		si.terms[term] = docs
+	}
+	p, ok := docs[id]
+	if !ok {
+		p = &posting{}
+		docs[id] = p
+	}
+	return p
+}
+
+// search scores every document that holds at least one of the terms with TF-IDF, weighting title matches by titleBoost.  Documents that match more of the terms rank higher.
+func (si *searchIndex) search(terms []string) []SearchResult {
+	scores := make(map[int]float64)
+	matched := make(map[int]int)
+	n := float64(len(si.docs))
+	for _, term := range terms {
+		docs := si.terms[term]
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(docs)))
+		for id, p := range docs {
+			tf := (float64(p.body) + titleBoost*float64(p.title)) / float64(si.docs[id].length+1)
+			scores[id] += tf * idf
+			matched[id]++
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		doc := si.docs[id]
+		results = append(results, SearchResult{
+			Post:    doc.post,
+			Score:   score * float64(matched[id]) / float64(len(terms)),
+			Snippet: snippet(doc.text, terms),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Post.CreatedAt.After(results[j].Post.CreatedAt)
+	})
+	return results
+}
+
+// #endregion
+
+// #region TEXT HELPERS
+
+var (
+	// tagRegex matches HTML tags.  Rendered markdown is trusted, so this doesn't need to handle every edge case of HTML.
+	tagRegex   = regexp.MustCompile(`<[^>]*>`)
+	spaceRegex = regexp.MustCompile(`\s+`)
+)
+
+// plainText strips the markup from rendered HTML, leaving words separated by single spaces.
+func plainText(body string) string {
+	text := tagRegex.ReplaceAllString(body, " ")
+	text = html.UnescapeString(text)
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(text, " "))
+}
+
+// tokenize splits text into lowercase terms of letters and digits.  Single characters are dropped.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if len([]rune(w)) > 1 {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// snippet cuts a window of words out of text around the first word that matches one of the terms, splitting it into highlighted and plain parts.
+func snippet(text string, terms []string) []SnippetPart {
+	isTerm := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		isTerm[t] = true
+	}
+	matches := func(word string) bool {
+		for _, t := range tokenize(word) {
+			if isTerm[t] {
+				return true
+			}
+		}
+		return false
+	}
+
+	words := strings.Fields(text)
+	start := 0
+	for i, w := range words {
+		if matches(w) {
+			start = i - snippetWords/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	var parts []SnippetPart
+	if start > 0 {
+		parts = append(parts, SnippetPart{Text: "… "})
+	}
+	var plain []string
+	for _, w := range words[start:end] {
+		if !matches(w) {
+			plain = append(plain, w)
+			continue
+		}
+		if len(plain) > 0 {
+			parts = append(parts, SnippetPart{Text: strings.Join(plain, " ") + " "})
+			plain = nil
+		}
+		parts = append(parts, SnippetPart{Text: w, Match: true}, SnippetPart{Text: " "})
+	}
+	if len(plain) > 0 {
+		parts = append(parts, SnippetPart{Text: strings.Join(plain, " ")})
+	}
+	if end < len(words) {
+		parts = append(parts, SnippetPart{Text: " …"})
+	}
+	return parts
+}
+
+// #endregion
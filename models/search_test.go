@@ -0,0 +1,156 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memorySearchSource is a SearchSource that holds posts and their rendered bodies in memory.  A post without a body acts like one whose markdown file is missing.
+type memorySearchSource struct {
+	posts  []Post
+	bodies map[string]string
+	calls  int
+}
+
+func (src *memorySearchSource) GetAll() ([]Post, error) {
+	src.calls++
+	return src.posts, nil
+}
+
+func (src *memorySearchSource) ParseMD(post *Post) error {
+	body, ok := src.bodies[post.URLPath]
+	if !ok {
+		return errors.New("no such file")
+	}
+	post.Body = body
+	return nil
+}
+
+func newTestSearch() (*memorySearchSource, SearchService) {
+	src := &memorySearchSource{
+		posts: []Post{
+			{Title: "Gardening in Autumn", URLPath: "gardening"},
+			{Title: "Writing Go", URLPath: "go"},
+			{Title: "Notes on Gophers", URLPath: "gophers"},
+			{Title: "Missing File", URLPath: "missing"},
+		},
+		bodies: map[string]string{
+			"gardening": "<p>Rake the leaves and plant bulbs before the frost.</p>",
+			"go":        "<p>Go is a small language.  Gophers write <em>Go</em> with gofmt &amp; go vet.</p>",
+			"gophers":   "<p>The gopher is the mascot of Go, and gophers also dig up gardens.</p>",
+		},
+	}
+	return src, NewSearchService(src)
+}
+
+func TestSearchRanking(t *testing.T) {
+	_, ss := newTestSearch()
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// "go" shows up most often in its own post, and the title counts for more.
+		{"go", []string{"go", "gophers"}},
+		{"Gophers", []string{"gophers", "go"}},
+		// Posts that match more of the terms rank higher.
+		{"gophers gardens", []string{"gophers", "go"}},
+		{"frost", []string{"gardening"}},
+		// Posts whose file is missing can still be found by their title.
+		{"missing", []string{"missing"}},
+		{"nothing", nil},
+		{"!", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := ss.Search(tt.query, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Post.URLPath)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	_, ss := newTestSearch()
+	results, err := ss.Search("go gophers", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("got %d results, want 1", len(results))
+	}
+}
+
+func TestSearchBuildsIndexOnce(t *testing.T) {
+	src, ss := newTestSearch()
+	ss.Search("go", 0)
+	ss.Search("frost", 0)
+	if src.calls != 1 {
+		t.Errorf("index was built %d times, want 1", src.calls)
+	}
+	if err := ss.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	if src.calls != 2 {
+		t.Errorf("index was built %d times after Rebuild, want 2", src.calls)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	_, ss := newTestSearch()
+	results, err := ss.Search("go", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	var matches []string
+	for _, part := range results[0].Snippet {
+		text.WriteString(part.Text)
+		if part.Match {
+			matches = append(matches, part.Text)
+		}
+	}
+	// Markup is stripped and entities are decoded.
+	if want := "Go is a small language. Gophers write Go with gofmt & go vet."; text.String() != want {
+		t.Errorf("snippet = %q, want %q", text.String(), want)
+	}
+	// Only whole words are highlighted, so "Gophers" and "gofmt" are not.
+	if want := "Go,Go,go"; strings.Join(matches, ",") != want {
+		t.Errorf("highlighted %v, want %s", matches, want)
+	}
+}
+
+func TestSnippetWindow(t *testing.T) {
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = "filler"
+	}
+	words[60] = "needle"
+	parts := snippet(strings.Join(words, " "), []string{"needle"})
+	if len(parts) == 0 || parts[0].Text != "… " || parts[len(parts)-1].Text != " …" {
+		t.Fatalf("snippet in the middle of the text should be cut on both ends: %v", parts)
+	}
+	var found bool
+	var count int
+	for _, part := range parts {
+		if part.Match {
+			found = part.Text == "needle"
+		}
+		count += len(strings.Fields(part.Text))
+	}
+	if !found {
+		t.Error("needle was not highlighted")
+	}
+	// The ellipses count as a field each.
+	if count != snippetWords+2 {
+		t.Errorf("snippet has %d words, want %d", count-2, snippetWords)
+	}
+}
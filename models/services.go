@@ -1,6 +1,8 @@
 package models
 
 import (
+	"log"
+
 	"github.com/jinzhu/gorm"
 	// Since this is implicitly needed by gorm
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -8,10 +10,16 @@ import (
 
 // Services will hold information about the varying services used in the models package.
 type Services struct {
-	User   UserService
-	Posts  PostsService
-	Images ImagesService
-	db     *gorm.DB
+	Site     Site
+	User     UserService
+	Sessions SessionService
+	Logins   *LoginLimits
+	Posts    PostsService
+	Images   ImagesService
+	Search   SearchService
+	Feeds    FeedsService
+	WebSub   WebSubNotifier
+	db       *gorm.DB
 }
 
 // NewServices will accept a list of config functions to run.  Each function will accept a pointer to the current Services object, manipulate that object, returning an error if there is one.
@@ -56,6 +64,32 @@ func WithUser(pepper, hmacKey string) ServicesConfig {
 	}
 }
 
+// WithSessions is a functional option that will construct a new session service, hashing tokens with the HMAC key.
+func WithSessions(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Sessions = NewSessionService(s.db, hmacKey)
+		return nil
+	}
+}
+
+// WithLoginLimits is a functional option that will limit failed logins per account and per client.  Failures are kept in the database unless inMemory is set.
+func WithLoginLimits(inMemory bool) ServicesConfig {
+	return func(s *Services) error {
+		if inMemory {
+			s.Logins = &LoginLimits{
+				Accounts: NewMemoryLimiter(AccountPolicy),
+				IPs:      NewMemoryLimiter(IPPolicy),
+			}
+			return nil
+		}
+		s.Logins = &LoginLimits{
+			Accounts: NewDBLimiter(s.db, AccountPolicy),
+			IPs:      NewDBLimiter(s.db, IPPolicy),
+		}
+		return nil
+	}
+}
+
 // WithPosts is a functional option that will construct a new posts service.
 func WithPosts(isProd bool) ServicesConfig {
 	return func(s *Services) error {
@@ -72,19 +106,85 @@ func WithImages() ServicesConfig {
 	}
 }
 
+// WithSearch is a functional option that will construct a new search service.  It must come after WithPosts, since the index is rebuilt whenever posts change.
+func WithSearch() ServicesConfig {
+	return func(s *Services) error {
+		s.Search = NewSearchService(s.Posts)
+		s.Posts.OnChange(func() {
+			if err := s.Search.Rebuild(); err != nil {
+				log.Println(err)
+			}
+		})
+		return nil
+	}
+}
+
+// WithSite is a functional option that will set the identity of the site.  It must come before any option that builds absolute URLs, such as WithFeeds.
+func WithSite(site Site) ServicesConfig {
+	return func(s *Services) error {
+		s.Site = site
+		return nil
+	}
+}
+
+// WithFeeds is a functional option that will construct a new feeds service.  It must come after WithSite, WithPosts and WithImages, since the feeds are rebuilt whenever posts change and use the images of posts.
+func WithFeeds(settings FeedSettings) ServicesConfig {
+	return func(s *Services) error {
+		s.Feeds = NewFeedsService(s.Posts, s.Images, s.Site, settings)
+		s.Posts.OnChange(func() {
+			if err := s.Feeds.Rebuild(); err != nil {
+				log.Println(err)
+			}
+		})
+		return nil
+	}
+}
+
+// WithWebSub is a functional option that will ping the WebSub hub of the feeds whenever posts change, signing pings with secret.  It must come after WithFeeds, so that the feeds are rebuilt before the hub fetches them.  It does nothing when the feeds have no hub.
+func WithWebSub(secret string) ServicesConfig {
+	return func(s *Services) error {
+		if s.Feeds.Hub() == "" {
+			return nil
+		}
+		s.WebSub = NewWebSubNotifier(s.Feeds.Hub(), secret)
+		s.Posts.OnChange(func() {
+			// Retries can take a while, so they shouldn't hold up whoever changed the posts.
+			topics := s.Feeds.Topics()
+			go func() {
+				if err := s.WebSub.Notify(topics); err != nil {
+					log.Println(err)
+				}
+			}()
+		})
+		return nil
+	}
+}
+
 // Close shuts down the connection to the database
 func (s *Services) Close() error {
 	return s.db.Close()
 }
 
-// AutoMigrate will attempt to automatically migrate tables
+// AutoMigrate will attempt to automatically migrate tables, then fill in any columns that new code expects to be set
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Post{}).Error
+	if err := s.db.AutoMigrate(&User{}, &pwReset{}, &Session{}, &recoveryCode{}, &loginAttempt{}, &Post{}, &Tag{}, &PostRevision{}).Error; err != nil {
+		return err
+	}
+	// Sessions replaced remember tokens, whose column would keep new users from being saved.
+	if err := relaxRememberHashes(s.db); err != nil {
+		return err
+	}
+	// Admins from before roles existed keep their access.
+	if err := backfillRoles(s.db); err != nil {
+		return err
+	}
+	// Posts from before GUIDs existed get theirs now.
+	return backfillGUIDs(s.db)
 }
 
 // DestructiveReset will drop tables and call AutoMigrate
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Post{}).Error
+	err := s.db.DropTableIfExists(&User{}, &pwReset{}, &Session{}, &recoveryCode{}, &loginAttempt{}, &Post{}, &Tag{}, "post_tags", &PostRevision{}).Error
 	if err != nil {
 		return err
 	}
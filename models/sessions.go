@@ -0,0 +1,274 @@
+package models
+
+import (
+	"time"
+
+	"nathanielwheeler.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// sessionLifetime is how long a session lasts without being used.
+	sessionLifetime = 30 * 24 * time.Hour
+	// sessionTouchInterval is how often the last seen time of a session is written, so that not every request writes to the database.
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a single login on a single device.  Only the HMAC hash of its token is stored, so a leaked database can't be used to sign in.
+type Session struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string `gorm:"type:text"`
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+// SessionDB is used to interact with the sessions database.
+type SessionDB interface {
+	// ByToken gets a session that hasn't expired yet.
+	ByToken(token string) (*Session, error)
+	// ByUser gets the sessions of a user that haven't expired yet, most recently seen first.
+	ByUser(userID uint) ([]Session, error)
+	Create(session *Session) error
+	Update(session *Session) error
+	// Delete removes a session, as long as it belongs to the user.
+	Delete(userID, id uint) error
+	// DeleteByUser removes every session of a user.
+	DeleteByUser(userID uint) error
+	DeleteExpired() error
+}
+
+// relaxRememberHashes lets the remember token column that sessions replaced be left empty.  It was not null, so new users couldn't be saved without a token that nothing reads anymore.  The column and its old values are kept, so that nothing is lost on boot.  Null values don't clash in its unique index.
+func relaxRememberHashes(db *gorm.DB) error {
+	if !db.Dialect().HasColumn("users", "remember_hash") {
+		return nil
+	}
+	return db.Exec("ALTER TABLE users ALTER COLUMN remember_hash DROP NOT NULL").Error
+}
+
+// #region SERVICE
+
+// SessionService is a set of methods used to handle business rules of sessions.
+type SessionService interface {
+	// Start signs a user in on a new device, returning a session that holds the token for their cookie.
+	Start(user *User, userAgent, ip string) (*Session, error)
+	// Touch records that a session was just used, pushing back when it expires.
+	Touch(session *Session) error
+	SessionDB
+}
+
+type sessionService struct {
+	SessionDB
+}
+
+// NewSessionService is the constructor of SessionService.  Tokens are hashed with hmacKey.
+func NewSessionService(db *gorm.DB, hmacKey string) SessionService {
+	sg := &sessionGorm{db}
+	sv := newSessionValidator(sg, hmacKey)
+	return &sessionService{
+		SessionDB: sv,
+	}
+}
+
+// Start creates a new session for the user.  Sessions that have expired are cleaned up while we're at it.
+func (ss *sessionService) Start(user *User, userAgent, ip string) (*Session, error) {
+	if err := ss.DeleteExpired(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionLifetime),
+	}
+	if err := ss.Create(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Touch updates the last seen time and expiry of a session, at most once every sessionTouchInterval.
+func (ss *sessionService) Touch(session *Session) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(sessionLifetime)
+	return ss.Update(session)
+}
+
+// #endregion
+
+// #region GORM
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+// ByToken gets an unexpired session given the hash of its token.
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	db := sg.db.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now())
+	if err := first(db, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ByUser gets the unexpired sessions of a user, most recently seen first.
+func (sg *sessionGorm) ByUser(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Create takes in a validated session and adds it to the database.
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+// Update only writes the times of a session, since nothing else about it ever changes.
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Model(session).Updates(map[string]interface{}{
+		"last_seen_at": session.LastSeenAt,
+		"expires_at":   session.ExpiresAt,
+	}).Error
+}
+
+// Delete removes a session of a user.  Asking for the session of someone else does nothing.
+func (sg *sessionGorm) Delete(userID, id uint) error {
+	return sg.db.Where("user_id = ? AND id = ?", userID, id).Delete(&Session{}).Error
+}
+
+// DeleteByUser removes every session of a user.
+func (sg *sessionGorm) DeleteByUser(userID uint) error {
+	return sg.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+// DeleteExpired removes every session that has expired.
+func (sg *sessionGorm) DeleteExpired() error {
+	return sg.db.Where("expires_at <= ?", time.Now()).Delete(&Session{}).Error
+}
+
+// #endregion
+
+// #region VALIDATION
+
+// sessionValidator hashes tokens with hmacWith.
+type sessionValidator struct {
+	SessionDB
+	hmacKey string
+}
+
+func newSessionValidator(db SessionDB, hmacKey string) *sessionValidator {
+	return &sessionValidator{
+		SessionDB: db,
+		hmacKey:   hmacKey,
+	}
+}
+
+// ByToken will hash the token before looking it up.
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	session := Session{Token: token}
+	if err := runSessionValFns(&session, sv.hmacToken); err != nil {
+		return nil, err
+	}
+	return sv.SessionDB.ByToken(session.TokenHash)
+}
+
+// Create will give the session a token and hash it before it is saved.
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFns(session,
+		sv.requireUserID,
+		sv.setTokenIfUnset,
+		sv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+// Update makes sure that the session has an ID, since gorm would otherwise update every session.
+func (sv *sessionValidator) Update(session *Session) error {
+	if session.ID == 0 {
+		return errIDInvalid
+	}
+	return sv.SessionDB.Update(session)
+}
+
+// Delete makes sure that an ID of zero never reaches the database.
+func (sv *sessionValidator) Delete(userID, id uint) error {
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	if id == 0 {
+		return errIDInvalid
+	}
+	return sv.SessionDB.Delete(userID, id)
+}
+
+// DeleteByUser makes sure that a user ID of zero never reaches the database.
+func (sv *sessionValidator) DeleteByUser(userID uint) error {
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	return sv.SessionDB.DeleteByUser(userID)
+}
+
+type sessionValFn func(*Session) error
+
+// runSessionValFns runs each validation function against a session, stopping at the first error.
+func runSessionValFns(session *Session, fns ...sessionValFn) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireUserID makes sure that the session belongs to someone.
+func (sv *sessionValidator) requireUserID(session *Session) error {
+	if session.UserID == 0 {
+		return errUserIDRequired
+	}
+	return nil
+}
+
+// setTokenIfUnset gives the session a random token of rand.TokenBytes bytes.
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.Token()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+// hmacToken hashes the token of the session, if there is one.
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = hmacWith(sv.hmacKey, session.Token)
+	return nil
+}
+
+// #endregion
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signToken makes a token that proves the user was given it for purpose, looking like "id.expires.signature".  Tokens like these don't need to be stored.  Anything that should void the token when it changes, such as the email address of the user, is passed as bind and signed along with the rest.
+func (us *userService) signToken(purpose string, user *User, bind string, lifetime time.Duration) string {
+	expires := time.Now().Add(lifetime).Unix()
+	sig := us.tokenSig(purpose, user.ID, bind, expires)
+	return fmt.Sprintf("%d.%d.%s", user.ID, expires, sig)
+}
+
+// userBySignedToken checks the signature and expiry of a token from signToken and returns its user.  Tokens that are malformed, expired, or were signed for a different purpose or bind return ErrTokenInvalid.
+func (us *userService) userBySignedToken(purpose, token string, bind func(*User) string) (*User, error) {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(uint(id))
+	if err == ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	sig := us.tokenSig(purpose, user.ID, bind(user), expires)
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(parts[2])) != 1 {
+		return nil, ErrTokenInvalid
+	}
+	return user, nil
+}
+
+// tokenSig signs the parts of a token.
+func (us *userService) tokenSig(purpose string, id uint, bind string, expires int64) string {
+	return hmacWith(us.hmacKey, fmt.Sprintf("%s:%d:%s:%d", purpose, id, bind, expires))
+}
@@ -0,0 +1,17 @@
+package models
+
+import "strings"
+
+// Site describes who runs the site and where it lives.  Anything that leaves the site, such as feeds and links in emails, uses it to build absolute URLs.
+type Site struct {
+	Title       string
+	BaseURL     string
+	Author      string
+	Email       string
+	Description string
+}
+
+// URL turns a path on the site into an absolute URL: "/blog" becomes "https://example.com/blog".
+func (s Site) URL(path string) string {
+	return strings.TrimRight(s.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SitemapEntry is the part of a post that a sitemap needs.
+type SitemapEntry struct {
+	URLPath   string
+	UpdatedAt time.Time
+}
+
+// SitemapEntries will return the URL path and last update of every published post, from newest to oldest.  Only those two columns are read, since sitemaps list every post at once.
+func (pg *postsGorm) SitemapEntries() ([]SitemapEntry, error) {
+	var entries []SitemapEntry
+	err := published(pg.db.Model(&Post{})).
+		Select("url_path, updated_at").
+		Order("created_at DESC").
+		Scan(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
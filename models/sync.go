@@ -0,0 +1,239 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MarkdownDir is the directory that holds the markdown of every post.  Posts are synced against the files found here.
+const MarkdownDir = "public/markdown"
+
+// SyncAction describes what a sync did (or would do) to a single post.
+type SyncAction string
+
+const (
+	// SyncCreate means that a markdown file has no post yet.
+	SyncCreate SyncAction = "create"
+	// SyncUpdate means that the front matter of a file no longer matches its post.
+	SyncUpdate SyncAction = "update"
+	// SyncDelete means that the file of a post is gone, so the post is soft-deleted.
+	SyncDelete SyncAction = "delete"
+)
+
+// SyncChange is a single line of a SyncReport.
+type SyncChange struct {
+	Action SyncAction
+	Post   Post
+	// Fields lists which columns an update touches.
+	Fields []string
+}
+
+// SyncReport describes everything a sync changed.  When DryRun is set, nothing was written to the database.
+type SyncReport struct {
+	DryRun    bool
+	Changes   []SyncChange
+	Unchanged int
+	// Errors holds files that could not be parsed.  They do not stop the rest of the sync.
+	Errors []string
+}
+
+// HasChanges returns true when the sync touched at least one post.
+func (r *SyncReport) HasChanges() bool {
+	return len(r.Changes) > 0
+}
+
+// Sync walks MarkdownDir and makes the posts table match it.  Files without a post are created, posts whose front matter changed are updated, and posts whose file is gone are soft-deleted.  A dry run only builds the report.
+func (ps *postsService) Sync(dryRun bool) (*SyncReport, error) {
+	report := SyncReport{DryRun: dryRun}
+
+	files, err := markdownFiles(MarkdownDir)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := ps.PostsDB.GetAllWithDrafts()
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]Post, len(existing))
+	for _, post := range existing {
+		byPath[post.FilePath] = post
+	}
+
+	for _, path := range files {
+		post, ok := byPath[path]
+		delete(byPath, path)
+		if !ok {
+			post = Post{FilePath: path}
+		}
+		if err := ps.ParseMD(&post); err != nil {
+			report.Errors = append(report.Errors, path+": "+err.Error())
+			continue
+		}
+		fields := applyFrontMatter(&post, !ok)
+		switch {
+		case !ok:
+			if !dryRun {
+				if err := ps.PostsDB.Create(&post); err != nil {
+					report.Errors = append(report.Errors, path+": "+err.Error())
+					continue
+				}
+				if err := ps.PostsDB.SetTags(&post, post.Tags); err != nil {
+					report.Errors = append(report.Errors, path+": "+err.Error())
+				}
+				ps.snapshot(&post)
+			}
+			report.Changes = append(report.Changes, SyncChange{Action: SyncCreate, Post: post})
+		case len(fields) > 0:
+			if !dryRun {
+				if err := ps.PostsDB.Update(&post); err != nil {
+					report.Errors = append(report.Errors, path+": "+err.Error())
+					continue
+				}
+				if hasField(fields, "Tags") {
+					if err := ps.PostsDB.SetTags(&post, post.Tags); err != nil {
+						report.Errors = append(report.Errors, path+": "+err.Error())
+					}
+				}
+			}
+			report.Changes = append(report.Changes, SyncChange{Action: SyncUpdate, Post: post, Fields: fields})
+		default:
+			report.Unchanged++
+		}
+		// Files edited outside of the app are recorded even when their front matter didn't change.
+		if ok && !dryRun {
+			ps.snapshot(&post)
+		}
+	}
+
+	// Anything left over no longer has a file on disk.
+	orphans := make([]Post, 0, len(byPath))
+	for _, post := range byPath {
+		orphans = append(orphans, post)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].FilePath < orphans[j].FilePath })
+	for _, post := range orphans {
+		if !dryRun {
+			if err := ps.PostsDB.Delete(post.ID); err != nil {
+				report.Errors = append(report.Errors, post.FilePath+": "+err.Error())
+				continue
+			}
+		}
+		report.Changes = append(report.Changes, SyncChange{Action: SyncDelete, Post: post})
+	}
+
+	if !dryRun && report.HasChanges() {
+		ps.changed()
+	}
+	return &report, nil
+}
+
+// #region HELPERS
+
+// markdownFiles returns the slash-separated path of every markdown file below root, sorted.
+func markdownFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyFrontMatter copies parsed front matter onto the columns of a post and returns the names of the columns it changed.  isNew lets a new post fall back on its file name for anything the front matter leaves out.
+func applyFrontMatter(post *Post, isNew bool) []string {
+	var fields []string
+
+	title := metaString(post.MetaData, "Title")
+	if title == "" && isNew {
+		title = strings.TrimSuffix(filepath.Base(post.FilePath), ".md")
+	}
+	if title != "" && title != post.Title {
+		post.Title = title
+		fields = append(fields, "Title")
+	}
+
+	urlpath := metaString(post.MetaData, "URLPath")
+	if urlpath == "" && isNew {
+		urlpath = defaultURLPath(post.FilePath)
+	}
+	if urlpath != "" && urlpath != post.URLPath {
+		post.URLPath = urlpath
+		fields = append(fields, "URLPath")
+	}
+
+	// Draft and PublishAt are only touched when the front matter sets them, so that the admin form can set them too.
+	if _, ok := post.MetaData["Draft"]; ok {
+		draft := metaBool(post.MetaData, "Draft")
+		if draft != post.Draft {
+			post.Draft = draft
+			fields = append(fields, "Draft")
+		}
+	}
+	if publishAt, ok := metaTime(post.MetaData, "PublishAt"); ok {
+		if post.PublishAt == nil || !post.PublishAt.Equal(publishAt) {
+			post.PublishAt = &publishAt
+			fields = append(fields, "PublishAt")
+		}
+	}
+
+	// Series are treated the same way, so that a post can be added to a series from either place.
+	if _, ok := post.MetaData["Series"]; ok {
+		series := metaString(post.MetaData, "Series")
+		if series != post.Series {
+			post.SetSeries(series)
+			fields = append(fields, "Series")
+		}
+	}
+	if _, ok := post.MetaData["SeriesOrder"]; ok {
+		order := metaInt(post.MetaData, "SeriesOrder")
+		if order != post.SeriesOrder {
+			post.SeriesOrder = order
+			fields = append(fields, "SeriesOrder")
+		}
+	}
+
+	tags := tagsFromMeta(post.MetaData)
+	if !sameTags(tags, post.Tags) {
+		post.Tags = tags
+		fields = append(fields, "Tags")
+	}
+
+	// The date in front matter is only used to backdate new posts, so that old files keep their place in the archive.
+	if isNew {
+		if date, ok := metaTime(post.MetaData, "Date"); ok {
+			post.CreatedAt = date
+		}
+	}
+
+	return fields
+}
+
+// hasField returns true if fields contains name.
+func hasField(fields []string, name string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultURLPath turns "public/markdown/2020/oct-1.md" into "2020/oct-1".
+func defaultURLPath(path string) string {
+	path = strings.TrimPrefix(path, MarkdownDir+"/")
+	return strings.TrimSuffix(path, ".md")
+}
+
+// #endregion
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tag is a subject that posts can be browsed by.  Tags come from the Tags and Categories keys in front matter, and are shared between posts through the post_tags table.
+type Tag struct {
+	ID   uint   `gorm:"primary_key"`
+	Name string `gorm:"not null"`
+	Slug string `gorm:"not null;unique_index"`
+}
+
+// TagCount is a tag along with the number of posts that use it.
+type TagCount struct {
+	Name  string
+	Slug  string
+	Count int
+}
+
+// tagKeys are the front matter keys read as tags.  Categories are treated as tags, so that readers can browse both the same way.
+var tagKeys = []string{"Tags", "Categories"}
+
+var slugRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a name into a lowercase, URL-safe slug: "Go & Web Dev" becomes "go-web-dev".
+func Slugify(name string) string {
+	s := slugRegex.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(s, "-")
+}
+
+// tagsFromMeta collects the tags of a post from its front matter.  Each key may hold a YAML list or a comma separated string.  Tags are deduplicated by slug and sorted.
+func tagsFromMeta(md map[string]interface{}) []Tag {
+	var names []string
+	for _, key := range tagKeys {
+		switch v := md[key].(type) {
+		case []interface{}:
+			for _, name := range v {
+				if s, ok := name.(string); ok {
+					names = append(names, s)
+				}
+			}
+		case string:
+			names = append(names, strings.Split(v, ",")...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var tags []Tag
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		slug := Slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, Tag{Name: name, Slug: slug})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Slug < tags[j].Slug })
+	return tags
+}
+
+// sameTags returns true if both slices hold the same tag slugs in the same order.
+func sameTags(a, b []Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Slug != b[i].Slug {
+			return false
+		}
+	}
+	return true
+}
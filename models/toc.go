@@ -0,0 +1,93 @@
+package models
+
+import (
+	"github.com/yuin/goldmark/ast"
+)
+
+// TOCEntry is a heading in the table of contents of a post.  Headings nested below it are its children.
+type TOCEntry struct {
+	ID       string
+	Title    string
+	Level    int
+	Children []TOCEntry
+}
+
+// tocEnabled returns false if the front matter turns the table of contents off with "TOC: false".
+func tocEnabled(md map[string]interface{}) bool {
+	if _, ok := md["TOC"]; !ok {
+		return true
+	}
+	return metaBool(md, "TOC")
+}
+
+// buildTOC collects the headings of a document into a tree.  A heading becomes a child of the closest heading before it with a lower level.
+func buildTOC(doc ast.Node, source []byte) []TOCEntry {
+	type node struct {
+		entry    TOCEntry
+		children []*node
+	}
+	root := &node{}
+	stack := []*node{root}
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		id, _ := heading.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		current := &node{entry: TOCEntry{
+			ID:    string(idBytes),
+			Title: string(heading.Text(source)),
+			Level: heading.Level,
+		}}
+		for len(stack) > 1 && stack[len(stack)-1].entry.Level >= heading.Level {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, current)
+		stack = append(stack, current)
+		return ast.WalkSkipChildren, nil
+	})
+
+	var flatten func(nodes []*node) []TOCEntry
+	flatten = func(nodes []*node) []TOCEntry {
+		if len(nodes) == 0 {
+			return nil
+		}
+		entries := make([]TOCEntry, len(nodes))
+		for i, n := range nodes {
+			entries[i] = n.entry
+			entries[i].Children = flatten(n.children)
+		}
+		return entries
+	}
+	return flatten(root.children)
+}
+
+// addHeadingAnchors appends a self-link to every heading with an ID, so that readers can link straight to a section.
+func addHeadingAnchors(doc ast.Node) {
+	var headings []*ast.Heading
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering {
+			headings = append(headings, heading)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, heading := range headings {
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			continue
+		}
+		idBytes, _ := id.([]byte)
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), idBytes...)
+		link.SetAttributeString("class", []byte("heading-anchor"))
+		link.Title = []byte("Link to this section")
+		link.AppendChild(link, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, ast.NewString([]byte(" ")))
+		heading.AppendChild(heading, link)
+	}
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"nathanielwheeler.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// totpPeriod, totpDigits and SHA-1 are the defaults of RFC 6238, which every authenticator app supports.
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods a code may be off by, to allow for clocks that drift and slow typing.
+	totpSkew = 1
+	// totpSecretBytes is the size of a secret.  RFC 4226 recommends 160 bits.
+	totpSecretBytes = 20
+	// recoveryCodeCount is how many recovery codes a user gets when they turn on two-factor authentication.
+	recoveryCodeCount = 10
+	// pendingLoginLifetime is how long a user has to enter their code after entering their password.
+	pendingLoginLifetime = 5 * time.Minute
+)
+
+// totpEncoding is the base32 that authenticator apps expect: upper case, without padding.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryCode is a one-time code that can stand in for a TOTP code when a user loses their authenticator.  Only the HMAC hash of the code is stored.
+type recoveryCode struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null;unique_index"`
+	CreatedAt time.Time
+}
+
+// recoveryCodeDB is used to interact with the recovery codes database.
+type recoveryCodeDB interface {
+	// ByCode gets a recovery code of a user given its hash.
+	ByCode(userID uint, codeHash string) (*recoveryCode, error)
+	Create(rc *recoveryCode) error
+	Delete(id uint) error
+	DeleteByUser(userID uint) error
+}
+
+// NewTOTPSecret generates a random secret for a user to add to their authenticator app.
+func NewTOTPSecret() (string, error) {
+	b, err := rand.Bytes(totpSecretBytes)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI builds the otpauth URI of a secret, which authenticator apps read from a QR code.  The issuer is shown in the app next to the email address of the user.
+func TOTPURI(issuer, email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	// Some apps read "+" as a space, so spaces are escaped the way they are in paths, and plus signs are always escaped.
+	label := strings.ReplaceAll(url.PathEscape(issuer+":"+email), "+", "%2B")
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+}
+
+// #region SERVICE
+
+// EnableTOTP turns on two-factor authentication for the user, once they prove that their authenticator app has the secret by entering a code from it.  It returns the recovery codes of the user, which can't be shown again.
+func (us *userService) EnableTOTP(user *User, secret, code string) ([]string, error) {
+	if user.TwoFactor() {
+		return nil, errTwoFactorOn
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(key) == 0 {
+		return nil, ErrCodeInvalid
+	}
+	step, ok := matchTOTP(key, normalizeCode(code), 0, time.Now())
+	if !ok {
+		return nil, ErrCodeInvalid
+	}
+	now := time.Now()
+	user.TOTPSecret = strings.ToUpper(secret)
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return us.newRecoveryCodes(user)
+}
+
+// DisableTOTP turns off two-factor authentication for the user.  It takes a code, so that someone who finds a device that is signed in can't turn it off.
+func (us *userService) DisableTOTP(user *User, code string) error {
+	if !user.TwoFactor() {
+		return errTwoFactorOff
+	}
+	if err := us.CheckTOTP(user, code); err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	return us.recoveryCodeDB.DeleteByUser(user.ID)
+}
+
+// CheckTOTP checks a code from the authenticator app of the user, or one of their recovery codes.  A TOTP code can't be used twice, and neither can a recovery code.
+func (us *userService) CheckTOTP(user *User, code string) error {
+	if !user.TwoFactor() {
+		return errTwoFactorOff
+	}
+	code = normalizeCode(code)
+	key, err := totpEncoding.DecodeString(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	if step, ok := matchTOTP(key, code, user.TOTPLastStep, time.Now()); ok {
+		user.TOTPLastStep = step
+		return us.Update(user)
+	}
+	rc, err := us.recoveryCodeDB.ByCode(user.ID, us.hashRecoveryCode(code))
+	if err == ErrNotFound {
+		return ErrCodeInvalid
+	}
+	if err != nil {
+		return err
+	}
+	return us.recoveryCodeDB.Delete(rc.ID)
+}
+
+// PendingLogin returns a signed token that stands in for the user between their password and their code.  It is signed along with the password hash, so a password reset voids it.
+func (us *userService) PendingLogin(user *User) string {
+	return us.signToken("2fa", user, user.PasswordHash, pendingLoginLifetime)
+}
+
+// ByPendingLogin returns the user of a token from PendingLogin.
+func (us *userService) ByPendingLogin(token string) (*User, error) {
+	return us.userBySignedToken("2fa", token, func(u *User) string { return u.PasswordHash })
+}
+
+// newRecoveryCodes replaces the recovery codes of the user with new ones.  The codes look like "k3jd-8fh2".
+func (us *userService) newRecoveryCodes(user *User) ([]string, error) {
+	if err := us.recoveryCodeDB.DeleteByUser(user.ID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := rand.Bytes(5)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		rc := recoveryCode{
+			UserID:   user.ID,
+			CodeHash: us.hashRecoveryCode(normalizeCode(codes[i])),
+		}
+		if err := us.recoveryCodeDB.Create(&rc); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a normalized recovery code.
+func (us *userService) hashRecoveryCode(code string) string {
+	return hmacWith(us.hmacKey, "recovery:"+code)
+}
+
+// #endregion
+
+// #region TOTP
+
+// matchTOTP looks for the period that code was made in, within totpSkew periods of now.  Periods up to and including after have already been used, so they are skipped.
+func matchTOTP(key []byte, code string, after int64, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode is the HOTP of RFC 4226 for a step, which is the counter of RFC 6238.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// Dynamic truncation picks four bytes based on the last nibble.
+	offset := sum[len(sum)-1] & 0x0f
+	bin := uint32(sum[offset]&0x7f)<<24 |
+		uint32(sum[offset+1])<<16 |
+		uint32(sum[offset+2])<<8 |
+		uint32(sum[offset+3])
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, bin%mod)
+}
+
+// normalizeCode strips the spaces and dashes that people type into codes, and lowercases recovery codes.
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// #endregion
+
+// #region GORM
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+// ByCode gets a recovery code of a user given its hash.
+func (rcg *recoveryCodeGorm) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	var rc recoveryCode
+	err := first(rcg.db.Where("user_id = ? AND code_hash = ?", userID, codeHash), &rc)
+	if err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// Create adds a hashed recovery code to the database.
+func (rcg *recoveryCodeGorm) Create(rc *recoveryCode) error {
+	return rcg.db.Create(rc).Error
+}
+
+// Delete removes a recovery code once it is used.
+func (rcg *recoveryCodeGorm) Delete(id uint) error {
+	if id == 0 {
+		return errIDInvalid
+	}
+	return rcg.db.Where("id = ?", id).Delete(&recoveryCode{}).Error
+}
+
+// DeleteByUser removes every recovery code of a user.
+func (rcg *recoveryCodeGorm) DeleteByUser(userID uint) error {
+	if userID == 0 {
+		return errUserIDRequired
+	}
+	return rcg.db.Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
+
+// #endregion
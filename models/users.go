@@ -3,9 +3,8 @@ package models
 import (
 	"regexp"
 	"strings"
-
-	"nathanielwheeler.com/hash"
-	"nathanielwheeler.com/rand"
+	"sync"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
@@ -18,9 +17,30 @@ type User struct {
 	Email        string `gorm:"type:varchar(100);primary key"`
 	Password     string `gorm:"-"` // Ensures that it won't be saved to database
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null; unique_index"`
-	IsAdmin      bool   `gorm:"default:false"`
+	// Role decides what the user can do on the site.  New users are readers.
+	Role Role `gorm:"not null;default:'reader'"`
+	// EmailVerifiedAt is when the user followed a verification link.  It is nil until they prove that they own their email address.
+	EmailVerifiedAt *time.Time
+	// VerificationSentAt is when the last verification email was sent, so that resends can be rate limited.
+	VerificationSentAt *time.Time
+	// ResetSentAt is when the last password reset email was sent, so that resets can be rate limited.
+	ResetSentAt *time.Time
+	// TOTPSecret is the base32 secret shared with the authenticator app of the user.  It has to be stored as is, since codes are made from it.
+	TOTPSecret string
+	// TOTPEnabledAt is when the user turned on two-factor authentication, or nil if they haven't.
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the period of the last code that was used, so that a code can't be used twice.
+	TOTPLastStep int64
+}
+
+// Verified returns true once the user has proven that they own their email address.
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TwoFactor returns true if the user needs a code from their authenticator app to sign in.
+func (u *User) TwoFactor() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 // UserDB is used to interact with the users database.
@@ -28,7 +48,6 @@ type UserDB interface {
 	// methods for single user queries
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
 	// methods for altering users
 	Create(user *User) error
 	Update(user *User) error
@@ -40,23 +59,47 @@ type UserDB interface {
 // UserService is a set of methods used to handle business rules of the user model
 type UserService interface {
 	Authenticate(email, password string) (*User, error)
+	// InitiateReset returns the user with the given email, along with a single-use token that lets them set a new password.  It returns ErrResetTooSoon if one was sent recently.
+	InitiateReset(email string) (*User, string, error)
+	// CompleteReset sets a new password using a token from InitiateReset.  Expired and used tokens return ErrTokenInvalid.
+	CompleteReset(token, newPw string) (*User, error)
+	// StartVerification returns a signed token that verifies the email address of the user.  It returns ErrVerifyTooSoon if one was sent recently.
+	StartVerification(user *User) (string, error)
+	// VerifyEmail marks the email address of the user a token from StartVerification was made for as verified.
+	VerifyEmail(token string) (*User, error)
+	// EnableTOTP turns on two-factor authentication with a secret from NewTOTPSecret, returning the recovery codes of the user.
+	EnableTOTP(user *User, secret, code string) ([]string, error)
+	// DisableTOTP turns off two-factor authentication, given a code.
+	DisableTOTP(user *User, code string) error
+	// CheckTOTP returns ErrCodeInvalid unless code is a fresh code from the authenticator app of the user, or an unused recovery code.
+	CheckTOTP(user *User, code string) error
+	// PendingLogin returns a short-lived token for a user that entered their password but still needs to enter a code.
+	PendingLogin(user *User) string
+	// ByPendingLogin returns the user of a token from PendingLogin.
+	ByPendingLogin(token string) (*User, error)
 	UserDB
 }
 
 // userService processes business rules for users
 type userService struct {
 	UserDB
-	pepper string
+	pwResetDB      pwResetDB
+	recoveryCodeDB recoveryCodeDB
+	pepper         string
+	hmacKey        string
 }
 
 // NewUserService : constructor for userService.  Calls constructors for user gorm and user validator.
 func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
 	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacKey)
-	uv := newUserValidator(ug, hmac, pepper)
+	uv := newUserValidator(ug, pepper)
+	pwrv := newPwResetValidator(&pwResetGorm{db}, hmacKey)
 	return &userService{
-		UserDB: uv,
-		pepper: pepper,
+		UserDB:         uv,
+		pwResetDB:      pwrv,
+		recoveryCodeDB: &recoveryCodeGorm{db},
+		pepper:         pepper,
+		hmacKey:        hmacKey,
 	}
 }
 
@@ -64,6 +107,11 @@ func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
 func (us *userService) Authenticate(email, password string) (*User, error) {
 	// Check if email exists
 	foundUser, err := us.ByEmail(email)
+	if err == ErrNotFound {
+		// Compare anyway, so that how long a login takes doesn't give away whether the email has an account.
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password+us.pepper))
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +129,19 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 	}
 }
 
+var (
+	dummyHashOnce sync.Once
+	dummyHashed   []byte
+)
+
+// dummyHash is a bcrypt hash with the default cost that matches no password.  It is made the first time it is needed, since bcrypt is slow on purpose.
+func dummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHashed, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	return dummyHashed
+}
+
 // #endregion
 
 // #region GORM
@@ -109,16 +170,6 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-// ByRemember gets a user given a remember token hash
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	var user User
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
 // Create takes in a validated user and adds it the database
 func (ug *userGorm) Create(user *User) error {
 	return ug.db.Create(user).Error
@@ -142,7 +193,6 @@ func (ug *userGorm) Delete(id uint) error {
 // userValidator represents the validation layer.  It also handles normalization.
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
 	pepper     string
 	emailRegex *regexp.Regexp
 	// TODO: make regex for password validation
@@ -150,10 +200,9 @@ type userValidator struct {
 }
 
 // Constructor for userValidator layer.  Needed so that I can compile regex and assign it.
-func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
+func newUserValidator(udb UserDB, pepper string) *userValidator {
 	return &userValidator{
 		UserDB: udb,
-		hmac:   hmac,
 		pepper: pepper,
 		emailRegex: regexp.MustCompile(
 			`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
@@ -183,17 +232,6 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-// ByRemember will hash the remember token then call ByRemember in the UserDB layer.
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	user := User{
-		Remember: token,
-	}
-	if err := runUserValFns(&user, uv.hmacRemember); err != nil {
-		return nil, err
-	}
-	return uv.UserDB.ByRemember(user.RememberHash)
-}
-
 // Create will make the provided user and backfill data like the ID, CreatedAt, and UpdatedAt fields.
 func (uv *userValidator) Create(user *User) error {
 	err := runUserValFns(user,
@@ -201,33 +239,29 @@ func (uv *userValidator) Create(user *User) error {
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.setRoleIfUnset,
+		uv.roleValid)
 	if err != nil {
 		return err
 	}
 	return uv.UserDB.Create(user)
 }
 
-// Update will hash a remember token if one is provided attached to the user object
+// Update will hash a new password if one is provided, and validate the rest of the user before it is saved
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.roleValid)
 	if err != nil {
 		return err
 	}
@@ -279,30 +313,6 @@ func (uv *userValidator) bcryptPassword(user *User) error {
 	return nil
 }
 
-// hmacRemember will hash a remember token if one is provided
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
-/* setRememberIfUnset will check the input user object to see if it has a remember token.
-If it does, it returns nil.
-If it doesn't, it calls rand.RememberToken() and adds the returned hash to the user. */
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.RememberHash = token
-	return nil
-}
-
 // idGreaterThan is to make sure that a uint of zero never reaches the delete function of Gorm, which would delete the entire database.
 func (uv *userValidator) idGreaterThan(n uint) userValFn {
 	return userValFn(func(user *User) error {
@@ -385,25 +395,18 @@ func (uv *userValidator) passwordHashRequired(user *User) error {
 	return nil
 }
 
-// rememberMinBytes will check if remember token has 32 bytes
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return errRememberTooShort
+// setRoleIfUnset makes new users readers.
+func (uv *userValidator) setRoleIfUnset(user *User) error {
+	if user.Role == "" {
+		user.Role = RoleReader
 	}
 	return nil
 }
 
-// rememberHashRequired checks if there is a remember token hash
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return errRememberRequired
+// roleValid makes sure that the user has one of the known roles.
+func (uv *userValidator) roleValid(user *User) error {
+	if !user.Role.Valid() {
+		return errRoleInvalid
 	}
 	return nil
 }
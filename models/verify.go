@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	// verifyTokenLifetime is how long a verification link works after it is sent.
+	verifyTokenLifetime = 48 * time.Hour
+	// verifyResendInterval is how long a user has to wait between verification emails.
+	verifyResendInterval = 5 * time.Minute
+)
+
+// StartVerification records that a verification email is being sent to the user and returns the token for its link.  Tokens aren't stored, and are signed along with the email address, so changing the address voids every link sent to the old one.
+func (us *userService) StartVerification(user *User) (string, error) {
+	if user.Verified() {
+		return "", errAlreadyVerified
+	}
+	now := time.Now()
+	if user.VerificationSentAt != nil && now.Sub(*user.VerificationSentAt) < verifyResendInterval {
+		return "", ErrVerifyTooSoon
+	}
+	user.VerificationSentAt = &now
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+	return us.signToken("verify", user, user.Email, verifyTokenLifetime), nil
+}
+
+// VerifyEmail checks the signature and expiry of a token, then marks the email address of its user as verified.  Following a link again after that does no harm.
+func (us *userService) VerifyEmail(token string) (*User, error) {
+	user, err := us.userBySignedToken("verify", token, func(u *User) string { return u.Email })
+	if err != nil {
+		return nil, err
+	}
+	if user.Verified() {
+		return user, nil
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// webSubAttempts is how many times a ping is sent before the notifier gives up.
+	webSubAttempts = 4
+	// webSubBackoff is how long the notifier waits after the first failed ping.  The wait doubles after every failure.
+	webSubBackoff = 2 * time.Second
+)
+
+// WebSubNotifier tells a WebSub hub that feeds have new content, so that the hub can push them to subscribers.
+type WebSubNotifier interface {
+	// Notify pings the hub about every topic in a single request, retrying with exponential backoff when the hub can't be reached or fails.
+	Notify(topics []string) error
+}
+
+type webSubNotifier struct {
+	hubURL  string
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+}
+
+// NewWebSubNotifier is the constructor of WebSubNotifier.  Pings are signed with an HMAC-SHA256 of their body using secret, sent in the X-Hub-Signature header.  The secret is shared with the hub, so it should be used for nothing else.  Pings are not signed when it is empty.
+func NewWebSubNotifier(hubURL, secret string) WebSubNotifier {
+	return &webSubNotifier{
+		hubURL:  hubURL,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: webSubBackoff,
+	}
+}
+
+func (wn *webSubNotifier) Notify(topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	form := url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  topics,
+	}
+	body := form.Encode()
+
+	wait := wn.backoff
+	var err error
+	for attempt := 1; attempt <= webSubAttempts; attempt++ {
+		var retry bool
+		if retry, err = wn.ping(body); err == nil || !retry {
+			return err
+		}
+		if attempt < webSubAttempts {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return err
+}
+
+// ping sends a single publish request to the hub.  retry is true when the error is worth another try: the hub couldn't be reached, is rate limiting or failed on its end.
+func (wn *webSubNotifier) ping(body string) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, wn.hubURL, strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(wn.secret) > 0 {
+		req.Header.Set("X-Hub-Signature", "sha256="+wn.sign(body))
+	}
+
+	res, err := wn.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("websub: hub answered %s", res.Status)
+	default:
+		return false, fmt.Errorf("websub: hub rejected the ping with %s", res.Status)
+	}
+}
+
+func (wn *webSubNotifier) sign(body string) string {
+	mac := hmac.New(sha256.New, wn.secret)
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHub is a WebSub hub that records the pings it gets and answers with the next status in its list.
+type testHub struct {
+	mu       sync.Mutex
+	statuses []int
+	pings    []*http.Request
+	bodies   []string
+}
+
+func (th *testHub) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.pings = append(th.pings, req)
+	th.bodies = append(th.bodies, string(body))
+	status := http.StatusNoContent
+	if len(th.statuses) > 0 {
+		status, th.statuses = th.statuses[0], th.statuses[1:]
+	}
+	res.WriteHeader(status)
+}
+
+func newTestNotifier(t *testing.T, hub *testHub, secret string) *webSubNotifier {
+	srv := httptest.NewServer(hub)
+	t.Cleanup(srv.Close)
+	wn := NewWebSubNotifier(srv.URL, secret).(*webSubNotifier)
+	wn.backoff = time.Millisecond
+	return wn
+}
+
+func TestWebSubPing(t *testing.T) {
+	hub := &testHub{}
+	wn := newTestNotifier(t, hub, "")
+	topics := []string{"https://example.com/feeds/all.atom", "https://example.com/feeds/all.rss"}
+	if err := wn.Notify(topics); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.pings) != 1 {
+		t.Fatalf("got %d pings, want 1", len(hub.pings))
+	}
+	form, err := url.ParseQuery(hub.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := form.Get("hub.mode"); mode != "publish" {
+		t.Errorf("hub.mode = %q, want publish", mode)
+	}
+	if got := form["hub.url"]; len(got) != 2 || got[0] != topics[0] || got[1] != topics[1] {
+		t.Errorf("hub.url = %v, want %v", got, topics)
+	}
+	if sig := hub.pings[0].Header.Get("X-Hub-Signature"); sig != "" {
+		t.Errorf("ping without a secret was signed: %q", sig)
+	}
+}
+
+func TestWebSubNoTopics(t *testing.T) {
+	hub := &testHub{}
+	wn := newTestNotifier(t, hub, "secret")
+	if err := wn.Notify(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.pings) != 0 {
+		t.Errorf("got %d pings for no topics, want 0", len(hub.pings))
+	}
+}
+
+func TestWebSubSignature(t *testing.T) {
+	hub := &testHub{}
+	wn := newTestNotifier(t, hub, "hub secret")
+	if err := wn.Notify([]string{"https://example.com/feeds/all.atom"}); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("hub secret"))
+	mac.Write([]byte(hub.bodies[0]))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := hub.pings[0].Header.Get("X-Hub-Signature"); sig != want {
+		t.Errorf("X-Hub-Signature = %q, want %q", sig, want)
+	}
+}
+
+func TestWebSubRetry(t *testing.T) {
+	hub := &testHub{statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusNoContent}}
+	wn := newTestNotifier(t, hub, "")
+	if err := wn.Notify([]string{"https://example.com/feeds/all.atom"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.pings) != 3 {
+		t.Errorf("got %d pings, want 3", len(hub.pings))
+	}
+}
+
+func TestWebSubGiveUp(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		pings  int
+	}{
+		{"server error", http.StatusInternalServerError, webSubAttempts},
+		{"rejected", http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &testHub{}
+			for i := 0; i < webSubAttempts; i++ {
+				hub.statuses = append(hub.statuses, tt.status)
+			}
+			wn := newTestNotifier(t, hub, "")
+			if err := wn.Notify([]string{"https://example.com/feeds/all.atom"}); err == nil {
+				t.Error("got no error, want one")
+			}
+			if len(hub.pings) != tt.pings {
+				t.Errorf("got %d pings, want %d", len(hub.pings), tt.pings)
+			}
+		})
+	}
+}
@@ -3,13 +3,15 @@ package rand
 import (
   "crypto/rand"
   "encoding/base64"
+  "fmt"
 )
 
-const rememberTokenBytes = 32
+// TokenBytes is the number of random bytes in every token made by Token.
+const TokenBytes = 32
 
-// RememberToken is a helper function designed to generate remember tokens of a set size
-func RememberToken() (string, error) {
-  return randString(rememberTokenBytes)
+// Token is a helper function designed to generate session and reset tokens of a set size
+func Token() (string, error) {
+  return randString(TokenBytes)
 }
 
 // Bytes will generate n bytes
@@ -22,7 +24,7 @@ func Bytes(n int) ([]byte, error) {
   return b, nil
 }
 
-// NBytes returns the number of bytes used in any string generated by the randString or RememberToken functions
+// NBytes returns the number of bytes used in any string generated by the randString or Token functions
 func NBytes(base64String string) (int, error) {
   b, err := base64.URLEncoding.DecodeString(base64String)
   if err != nil {
@@ -38,3 +40,14 @@ func randString(nBytes int) (string, error) {
   }
   return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// UUID will generate a random (version 4) UUID, such as "7d444840-9dc0-11d1-b245-5ffdce74fad2"
+func UUID() (string, error) {
+  b, err := Bytes(16)
+  if err != nil {
+    return "", err
+  }
+  b[6] = b[6]&0x0f | 0x40 // Version 4
+  b[8] = b[8]&0x3f | 0x80 // Variant 10
+  return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
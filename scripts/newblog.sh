@@ -35,4 +35,5 @@ d=$(date +"%B %-d, %Y")
 echo "---" >> $f
 echo "Title: \"$title\"" >> $f
 echo "Date: $d" >> $f
+echo "Tags: []" >> $f
 echo "---" >> $f
@@ -0,0 +1,74 @@
+package views
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// CachePublic lets browsers and proxies store a response, as long as they check it with the server before reusing it.
+	CachePublic = "public, no-cache"
+	// CachePrivate keeps shared caches from storing pages that belong to one visitor, such as pages with a CSRF token.
+	CachePrivate = "private, no-cache"
+)
+
+// startedAt is mixed into the ETag of every page, so that a deploy with new templates never answers 304 for a page rendered by the old ones.
+var startedAt = time.Now()
+
+// NotModified sets the Last-Modified and ETag validators of a response, then checks them against If-None-Match and If-Modified-Since.  If the client already has the latest version, it answers 304 and returns true, and the caller should write nothing else.
+func NotModified(res http.ResponseWriter, req *http.Request, modTime time.Time, etag string) bool {
+	h := res.Header()
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match wins over If-Modified-Since when a client sends both.
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		// HTTP dates have no fraction of a second.
+		if err != nil || modTime.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	res.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ETag builds a weak entity tag out of anything that identifies a version of a response.
+func ETag(parts ...interface{}) string {
+	sum := sha1.Sum([]byte(fmt.Sprint(parts...)))
+	return fmt.Sprintf(`W/"%x"`, sum[:10])
+}
+
+// pageETag is the entity tag of a page rendered from data last modified at modTime.
+func pageETag(modTime time.Time) string {
+	return ETag(startedAt.UnixNano(), " ", modTime.UnixNano())
+}
+
+// etagMatch reports whether an If-None-Match header holds etag.  Weak comparison is used, as it should be for GET requests.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
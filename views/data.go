@@ -1,6 +1,7 @@
 package views
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -10,9 +11,25 @@ import (
 
 // Data is the top level structure that views expect data to come in.
 type Data struct {
-	Alert *Alert
-	User  *models.User
-	Yield interface{}
+	Site       models.Site
+	Alert      *Alert
+	User       *models.User
+	Pagination *Pagination
+	// Description fills the meta description of the page.
+	Description string
+	// Feeds are advertised in the head of the page, so that feed readers can find them.
+	Feeds []FeedLink
+	// LastModified is when the content of the page last changed.  When it is set, Render sends validators and answers conditional requests with 304 Not Modified.
+	LastModified time.Time
+	Yield        interface{}
+}
+
+// FeedLink points to a feed of the page being rendered.  Title is added to the title of the site, and is left empty for the site-wide feed.
+type FeedLink struct {
+	Title  string
+	Format string
+	Type   string
+	Href   string
 }
 
 // PublicError is an interface applying to errors that have a Public method attached to them.
@@ -36,11 +53,13 @@ const (
 	AlertLvlInfo = "info"
 	// AlertLvlSuccess indicates that an action was carried successfully.
 	AlertLvlSuccess = "success"
-
-	// AlertMsgGeneric is the default message for unfiltered errors.
-	AlertMsgGeneric = `Something went wrong, please try again.  If the problem persists, please contact me directly at <a href="mailto:nathan@mailftp.com">nathan@mailftp.com</a>.`
 )
 
+// AlertMsgGeneric is the default message for unfiltered errors.  It points readers to the contact email of the site.
+func AlertMsgGeneric() string {
+	return fmt.Sprintf(`Something went wrong, please try again.  If the problem persists, please contact me directly at <a href="mailto:%[1]s">%[1]s</a>.`, site.Email)
+}
+
 // SetAlert will create an alert using a constant error message
 func (d *Data) SetAlert(err error) {
 	var msg string
@@ -48,7 +67,7 @@ func (d *Data) SetAlert(err error) {
 		msg = pErr.Public()
 	} else {
 		log.Println(err)
-		msg = AlertMsgGeneric
+		msg = AlertMsgGeneric()
 	}
 	d.Alert = &Alert{
 		Level:   AlertLvlError,
@@ -0,0 +1,77 @@
+package views
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// Pagination describes which page of a listing is being shown.  Templates use it to render previous and next links.
+type Pagination struct {
+	Page    int
+	PerPage int
+	Total   int
+	url     url.URL
+}
+
+// NewPagination builds the pagination of a listing.  u is the URL of the current request; its query is kept in the previous and next links.  Pages start at 1.
+func NewPagination(u *url.URL, page, perPage, total int) *Pagination {
+	if page < 1 {
+		page = 1
+	}
+	return &Pagination{
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+		url:     *u,
+	}
+}
+
+// Offset is the number of items before the current page.
+func (p *Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// Pages is the number of pages in the listing.  An empty listing still has one page.
+func (p *Pagination) Pages() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// InRange returns false if the page is past the end of the listing.
+func (p *Pagination) InRange() bool {
+	return p.Page <= p.Pages()
+}
+
+// HasPrev returns true if there is a page before this one.
+func (p *Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext returns true if there is a page after this one.
+func (p *Pagination) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// PrevURL links to the previous page.
+func (p *Pagination) PrevURL() string {
+	return p.pageURL(p.Page - 1)
+}
+
+// NextURL links to the next page.
+func (p *Pagination) NextURL() string {
+	return p.pageURL(p.Page + 1)
+}
+
+func (p *Pagination) pageURL(page int) string {
+	u := p.url
+	q := u.Query()
+	if page <= 1 {
+		q.Del("page")
+	} else {
+		q.Set("page", strconv.Itoa(page))
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
@@ -3,6 +3,7 @@ package views
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
@@ -18,8 +19,16 @@ import (
 var (
 	templateDir  string = "views/"
 	templateExt  string = ".html"
+
+	// site is shared by every view.  It is set once on startup by SetSite.
+	site models.Site
 )
 
+// SetSite sets the identity of the site that every view renders with.  It should be called before the server starts.
+func SetSite(s models.Site) {
+	site = s
+}
+
 // View : Contains a pointer to a template and the name of a layout.
 type View struct {
 	Template *template.Template
@@ -39,6 +48,10 @@ func NewView(layout string, files ...string) *View {
 			"csrfField": func() (template.HTML, error) {
 				return "", errors.New("csrfField is not implemented")
 			},
+			// can is a placeholder that is replaced in the render with a check of the permissions of the current user.
+			"can": func(perm string) bool {
+				return false
+			},
 			// pathEscape will escape a path using the net/url package.
 			"pathEscape": func(s string) string {
 				return url.PathEscape(s)
@@ -83,22 +96,44 @@ func (v *View) Render(res http.ResponseWriter, req *http.Request, data interface
 		clearAlert(res)
   }
   
+	vd.Site = site
+
 	// Lookup and set the user to the User field
 	vd.User = context.User(req.Context())
+
+	// Pages with a user or an alert are only ever seen by one visitor, so they are never validated.
+	if vd.User != nil || vd.Alert != nil {
+		res.Header().Set("Cache-Control", CachePrivate)
+	} else if !vd.LastModified.IsZero() {
+		res.Header().Set("Cache-Control", CachePublic)
+		if NotModified(res, req, vd.LastModified, pageETag(vd.LastModified)) {
+			return
+		}
+	}
 	var buf bytes.Buffer
 
 	// Create CSRF field using current http request and add it onto the template FuncMap.
   csrfField := csrf.TemplateField(req)
   
-	tpl := v.Template.Funcs(template.FuncMap{
+	// The funcs belong to this request, so they go on a copy of the template that no other request can see.
+	tpl, err := v.Template.Clone()
+	if err != nil {
+		http.Error(res, fmt.Sprintf(`Something went wrong, please try again.  If the problem persists, please contact me directly at "%s"`, site.Email), http.StatusInternalServerError)
+		return
+	}
+	tpl = tpl.Funcs(template.FuncMap{
 		"csrfField": func() template.HTML {
 			return csrfField
 		},
+		// can tells templates whether the current user may do something, like "posts:create", so they only show links that will work.
+		"can": func(perm string) bool {
+			return vd.User != nil && vd.User.Can(models.Permission(perm))
+		},
 	})
 
-	err := tpl.ExecuteTemplate(&buf, v.Layout, vd)
+	err = tpl.ExecuteTemplate(&buf, v.Layout, vd)
 	if err != nil {
-		http.Error(res, `Something went wrong, please try again.  If the problem persists, please contact me directly at "nathan@mailftp.com"`, http.StatusInternalServerError)
+		http.Error(res, fmt.Sprintf(`Something went wrong, please try again.  If the problem persists, please contact me directly at "%s"`, site.Email), http.StatusInternalServerError)
 		return
 	}
 	io.Copy(res, &buf)